@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SamHennessy/vsop/vsop"
+	"github.com/pkg/errors"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// configFile is read from the working directory unless --config says
+// otherwise
+const configFile = ".vsop.json"
+
+var (
+	// projectConfig from the config file, empty when there isn't one
+	projectConfig = &vsop.ProjectConfig{}
+	// configPath is the absolute path of the config file, which may not exist
+	configPath string
+)
+
+// loadConfigFile reads the config file and fills in the flags that weren't
+// set on the command line, by an env var or in .env
+func loadConfigFile(c *cli.Context) error {
+	if err := applyDotEnvFlags(c, dotEnvFlags); err != nil {
+		return err
+	}
+	path := c.GlobalString("config")
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	configPath = abs
+
+	if _, err := os.Stat(path); os.IsNotExist(err) && c.GlobalIsSet("config") {
+		return errors.Errorf("config file %s not found", path)
+	}
+	config, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if err := applyConfigFlags(c, config.Flags); err != nil {
+		return errors.Wrap(err, path)
+	}
+	if _, currentFlags, err = resolveFlags(c.App, config); err != nil {
+		return err
+	}
+	projectConfig = config
+	return nil
+}
+
+// readConfigFile loads the config file, an empty config when there isn't one
+func readConfigFile(path string) (*vsop.ProjectConfig, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return &vsop.ProjectConfig{}, nil
+	}
+	return vsop.LoadConfig(path)
+}
+
+// applyConfigFlags sets each flag from the file, flags and env vars win
+func applyConfigFlags(c *cli.Context, flags map[string]interface{}) error {
+	known := make(map[string]bool)
+	for _, name := range c.GlobalFlagNames() {
+		known[name] = true
+	}
+
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !known[name] || name == "config" {
+			return errors.Errorf("unknown setting %q", name)
+		}
+		if _, list := flags[name].([]interface{}); list {
+			if _, slice := c.GlobalGeneric(name).(*cli.StringSlice); !slice {
+				return errors.Errorf("%s takes one value, not a list", name)
+			}
+		}
+		if c.GlobalIsSet(name) {
+			continue
+		}
+		values, err := configValues(flags[name])
+		if err != nil {
+			return errors.Wrap(err, name)
+		}
+		for _, v := range values {
+			if err := c.GlobalSet(name, v); err != nil {
+				return errors.Wrap(err, name)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues turns a JSON value into flag values, arrays are for flags
+// that can be given more than once
+func configValues(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case json.Number:
+		return []string{v.String()}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, ok := item.([]interface{}); ok {
+				return nil, errors.New("nested arrays aren't allowed")
+			}
+			value, err := configValues(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value...)
+		}
+		return values, nil
+	}
+	return nil, errors.Errorf("unsupported value %v", v)
+}
+
+// initCommand writes a starter config file
+var initCommand = cli.Command{
+	Name:  "init",
+	Usage: "Write a commented " + configFile + " to start from",
+	Flags: []cli.Flag{
+		cli.BoolFlag{Name: "force,f", Usage: "overwrite an existing file"},
+	},
+	Action: func(c *cli.Context) error {
+		path := c.GlobalString("config")
+		if _, err := os.Stat(path); err == nil && !c.Bool("force") {
+			return cli.NewExitError(path+" already exists, use --force to overwrite it", 1)
+		}
+		if err := ioutil.WriteFile(path, starterConfig(c.App.Flags), 0644); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println("Wrote " + path)
+		return nil
+	},
+}
+
+// starterConfig lists every flag commented out with its default, followed by
+// the settings that only live in the file
+func starterConfig(flags []cli.Flag) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{
+  // vsop config. Settings are the command line flags without the dashes,
+  // flags and VSOP_* environment variables win over this file. Uncomment a
+  // setting to change it.
+
+`)
+	for _, f := range flags {
+		name := strings.Split(f.GetName(), ",")[0]
+		usage, value := "", ""
+		switch f := f.(type) {
+		case cli.StringFlag:
+			usage, value = f.Usage, strconv.Quote(f.Value)
+		case cli.IntFlag:
+			usage, value = f.Usage, strconv.Itoa(f.Value)
+		case cli.BoolFlag:
+			usage, value = f.Usage, "true"
+		case cli.DurationFlag:
+			usage, value = f.Usage, strconv.Quote(f.Value.String())
+		case cli.StringSliceFlag:
+			usage, value = f.Usage, "[]"
+		default:
+			continue
+		}
+		if name == "config" || name == "help" || name == "version" {
+			continue
+		}
+		fmt.Fprintf(&buf, "  // %s\n  // %q: %s,\n\n", usage, name, value)
+	}
+	buf.WriteString(`  "watch": {
+    // Extensions of files that trigger a build (any file with --all)
+    "extensions": [".go"],
+    // Changes to files matching these patterns are ignored, matched against
+    // the file name and the path relative to --path. Editor temp files are
+    // always ignored.
+    "ignore": []
+  },
+
+  // Set for the app when not already in the environment
+  "env": {},
+
+  // Commands run from the working directory with the app's environment, one
+  // command per entry. A failing preBuild or preRun command stops the build
+  // or run.
+  "hooks": {
+    "preBuild": [],
+    "postBuild": [],
+    "preRun": []
+  }
+}
+`)
+	return buf.Bytes()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/SamHennessy/vsop/vsop"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// testContext parses args with a few of vsop's flags
+func testContext(t *testing.T, args ...string) *cli.Context {
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "config,c", Value: configFile, EnvVar: "VSOP_TEST_CONFIG"},
+		cli.IntFlag{Name: "port,p", Value: 3000, EnvVar: "VSOP_TEST_PORT"},
+		cli.IntFlag{Name: "appPort,a", Value: 3001, EnvVar: "VSOP_TEST_APP_PORT"},
+		cli.StringFlag{Name: "buildArgs", EnvVar: "VSOP_TEST_BUILD_ARGS"},
+		cli.BoolFlag{Name: "immediate,i", EnvVar: "VSOP_TEST_IMMEDIATE"},
+		cli.StringSliceFlag{Name: "excludeDir,x", EnvVar: "VSOP_TEST_EXCLUDE_DIR"},
+	}
+	c, _, err := parseFlags(app, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// loadTestConfig writes a config file and loads it
+func loadTestConfig(t *testing.T, src string) (*vsop.ProjectConfig, error) {
+	dir, err := ioutil.TempDir("", "vsop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, configFile)
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return vsop.LoadConfig(path)
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	config := `{
+		// From the file
+		"port": 4000,
+		"appPort": 4001,
+		"buildArgs": "-race",
+		"immediate": true,
+		"excludeDir": ["node_modules", "tmp"]
+	}`
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		dotEnv  map[string]string
+		port    int
+		appPort int
+		args2   string
+		exclude []string
+	}{
+		{
+			name:    "file",
+			port:    4000,
+			appPort: 4001,
+			args2:   "-race",
+			exclude: []string{"node_modules", "tmp"},
+		},
+		{
+			name:    ".env wins over the file",
+			dotEnv:  map[string]string{"VSOP_TEST_PORT": "5000", "VSOP_TEST_EXCLUDE_DIR": "vendor, dist"},
+			port:    5000,
+			appPort: 4001,
+			args2:   "-race",
+			exclude: []string{"vendor", "dist"},
+		},
+		{
+			name:    "env wins over .env",
+			env:     map[string]string{"VSOP_TEST_PORT": "6000", "VSOP_TEST_BUILD_ARGS": "-v"},
+			dotEnv:  map[string]string{"VSOP_TEST_PORT": "5000"},
+			port:    6000,
+			appPort: 4001,
+			args2:   "-v",
+			exclude: []string{"node_modules", "tmp"},
+		},
+		{
+			name:    "flags win over env",
+			args:    []string{"-p", "7000", "--excludeDir", "build"},
+			env:     map[string]string{"VSOP_TEST_PORT": "6000", "VSOP_TEST_APP_PORT": "6001"},
+			dotEnv:  map[string]string{"VSOP_TEST_PORT": "5000", "VSOP_TEST_EXCLUDE_DIR": "vendor"},
+			port:    7000,
+			appPort: 6001,
+			args2:   "-race",
+			exclude: []string{"build"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+			c := testContext(t, tt.args...)
+			project, err := loadTestConfig(t, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := applyDotEnvFlags(c, tt.dotEnv); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyConfigFlags(c, project.Flags); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := c.GlobalInt("port"); got != tt.port {
+				t.Errorf("port = %d, want %d", got, tt.port)
+			}
+			if got := c.GlobalInt("appPort"); got != tt.appPort {
+				t.Errorf("appPort = %d, want %d", got, tt.appPort)
+			}
+			if got := c.GlobalString("buildArgs"); got != tt.args2 {
+				t.Errorf("buildArgs = %q, want %q", got, tt.args2)
+			}
+			if !c.GlobalBool("immediate") {
+				t.Error("immediate not set from the file")
+			}
+			if got := c.GlobalStringSlice("excludeDir"); !reflect.DeepEqual(got, tt.exclude) {
+				t.Errorf("excludeDir = %q, want %q", got, tt.exclude)
+			}
+		})
+	}
+}
+
+func TestConfigRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"unknown key", `{"prot": 4000}`, `unknown setting "prot"`},
+		{"config in the config", `{"config": "other.json"}`, `unknown setting "config"`},
+		{"list for one value", `{"port": [4000, 5000]}`, "port takes one value, not a list"},
+		{"nested list", `{"excludeDir": [["tmp"]]}`, "excludeDir: nested arrays aren't allowed"},
+		{"object for a flag", `{"buildArgs": {"race": true}}`, "buildArgs: unsupported value"},
+		// Rejected even when a flag would win over it
+		{"list set by a flag", `{"port": [4000]}`, "port takes one value, not a list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testContext(t, "-p", "7000")
+			project, err := loadTestConfig(t, tt.config)
+			if err == nil {
+				err = applyConfigFlags(c, project.Flags)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"unknown watch key", `{"watch": {"extension": [".go"]}}`},
+		{"unknown hook", `{"hooks": {"postRun": ["echo"]}}`},
+		{"env that isn't strings", `{"env": {"PORT": 3000}}`},
+		{"not JSON", `{"port": 3000,}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadTestConfig(t, tt.config); err == nil {
+				t.Errorf("%s loaded", tt.config)
+			}
+		})
+	}
+}
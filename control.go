@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/SamHennessy/vsop/vsop"
+	"github.com/pkg/errors"
+)
+
+// controlSocket is where the control API listens by default, relative to the
+// working directory
+const controlSocket = ".vsop.sock"
+
+// pidFile holds the pid and control address of the vsop running in the
+// working directory, for vsop ctl to find it
+const pidFile = ".vsop.pid"
+
+// How many log lines /logs returns when n isn't given
+const controlLogLines = 100
+
+var (
+	controlMu       sync.Mutex
+	controlListener net.Listener
+)
+
+// controlStatus is the JSON returned by /status and every action
+type controlStatus struct {
+	Build        int                `json:"build"`
+	Building     bool               `json:"building"`
+	DepRunning   bool               `json:"depRunning"`
+	Failed       bool               `json:"failed"`
+	Running      bool               `json:"running"`
+	Pid          int                `json:"pid,omitempty"`
+	Errors       []vsop.Diagnostic  `json:"errors,omitempty"`
+	ModuleErrors []vsop.ModuleError `json:"moduleErrors,omitempty"`
+	Output       string             `json:"output,omitempty"`
+	Tests        *controlTests      `json:"tests,omitempty"`
+}
+
+// controlTests summarises the last test run
+type controlTests struct {
+	Running  bool               `json:"running"`
+	Ok       bool               `json:"ok"`
+	Passed   int                `json:"passed"`
+	Failed   int                `json:"failed"`
+	Skipped  int                `json:"skipped"`
+	Failures []vsop.TestResult  `json:"failures,omitempty"`
+	Coverage map[string]float64 `json:"coverage,omitempty"`
+	// Dropped are the packages whose coverage went down
+	Dropped []string `json:"dropped,omitempty"`
+}
+
+// controlNetwork is tcp for a host:port, otherwise addr is a unix socket
+func controlNetwork(addr string) string {
+	if _, port, err := net.SplitHostPort(addr); err == nil {
+		if _, err := strconv.Atoi(port); err == nil {
+			return "tcp"
+		}
+	}
+	return "unix"
+}
+
+// controlTCPAddr puts a :port without a host on loopback. The API has no
+// authentication, so addresses other hosts can reach are refused.
+func controlTCPAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return addr, nil
+	}
+	return "", errors.Errorf("%s isn't a loopback address, the control API has no authentication", addr)
+}
+
+// startControl serves the control API on a unix socket, or on TCP when addr
+// is a host:port
+func startControl(addr string) error {
+	network := controlNetwork(addr)
+	if network == "tcp" {
+		var err error
+		if addr, err = controlTCPAddr(addr); err != nil {
+			return err
+		}
+	}
+	if network == "unix" {
+		if _, err := os.Stat(addr); err == nil {
+			// A socket left by a vsop that didn't shut down cleanly
+			if conn, err := net.Dial("unix", addr); err == nil {
+				conn.Close()
+				return errors.Errorf("another vsop is using %s", addr)
+			}
+			os.Remove(addr)
+		}
+	}
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", controlStatusHandler)
+	mux.HandleFunc("/logs", controlLogsHandler)
+	mux.HandleFunc("/build", controlAction(rebuild))
+	mux.HandleFunc("/run", controlAction(restart))
+	mux.HandleFunc("/restart", controlAction(restart))
+	mux.HandleFunc("/kill", controlAction(func() { killNow() }))
+	mux.HandleFunc("/deps", controlTry(fetchDeps))
+	mux.HandleFunc("/tidy", controlTry(tidy))
+	mux.HandleFunc("/test", controlAction(testAll))
+	mux.HandleFunc("/cover", controlCoverHandler)
+
+	controlMu.Lock()
+	controlListener = l
+	controlMu.Unlock()
+
+	go http.Serve(l, mux)
+
+	if err := ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), addr)), 0644); err != nil {
+		logV.Err(errors.Wrap(err, "write pid file"))
+	}
+
+	logV.Infof("Control API on %s %s", network, addr)
+	return nil
+}
+
+// stopControl closes the control listener, which removes the socket file, and
+// removes the pid file
+func stopControl() {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	if controlListener != nil {
+		controlListener.Close()
+		controlListener = nil
+		os.Remove(pidFile)
+	}
+}
+
+func currentStatus() controlStatus {
+	st := controlStatus{
+		Build:        builder.Builds(),
+		Building:     isBuilding() || builder.Building(),
+		DepRunning:   depsRunning(),
+		Running:      runner.IsRunning(),
+		Errors:       builder.Diagnostics(),
+		ModuleErrors: builder.ModuleErrors(),
+	}
+	st.Failed = builder.Errors() != ""
+	if st.Failed && len(st.Errors) == 0 && len(st.ModuleErrors) == 0 {
+		st.Output = builder.Errors()
+	}
+	if run := tester.Last(); run != nil {
+		st.Tests = &controlTests{
+			Running:  tester.Running(),
+			Ok:       run.Ok(),
+			Passed:   run.Passed,
+			Failed:   run.Failed,
+			Skipped:  run.Skipped,
+			Failures: run.FailedTests(),
+			Coverage: run.Coverage,
+			Dropped:  run.Dropped(),
+		}
+	}
+	if cmd := runner.Command(); st.Running && cmd != nil && cmd.Process != nil {
+		st.Pid = cmd.Process.Pid
+	}
+	return st
+}
+
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(v)
+}
+
+func controlStatusHandler(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, http.StatusOK, currentStatus())
+}
+
+// controlAction runs the action then responds with the status, the request
+// is held until the action is done so a build's result is in the response
+func controlAction(action func()) http.HandlerFunc {
+	return controlTry(func() bool {
+		if depsRunning() {
+			return false
+		}
+		action()
+		return true
+	})
+}
+
+// controlTry is controlAction for an action that refuses to start while
+// dependencies are being fetched, it returns false when it didn't run
+func controlTry(action func() bool) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			res.Header().Set("Allow", http.MethodPost)
+			writeJSON(res, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		logV.Debugf("Control API %s", req.URL.Path)
+		if !action() {
+			writeJSON(res, http.StatusConflict, map[string]string{"error": "dependencies are being fetched"})
+			return
+		}
+		writeJSON(res, http.StatusOK, currentStatus())
+	}
+}
+
+// controlCoverHandler writes the HTML coverage report and responds with its
+// path
+func controlCoverHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", http.MethodPost)
+		writeJSON(res, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+		return
+	}
+	if err := writeCoverReport(); err != nil {
+		writeJSON(res, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	path, _ := filepath.Abs(coverReport)
+	writeJSON(res, http.StatusOK, map[string]string{"path": path})
+}
+
+// controlNamespaces maps the log tab names to namespaces
+var controlNamespaces = map[string]string{
+	"app":  "A",
+	"vsop": "V",
+}
+
+// controlLogsHandler returns the last n log lines as a JSON array. With
+// follow=1 it sends one JSON line per log line and keeps going until the
+// client goes away.
+func controlLogsHandler(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	n := controlLogLines
+	if v := query.Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			writeJSON(res, http.StatusBadRequest, map[string]string{"error": "n must be a positive number"})
+			return
+		}
+	}
+	ns := ""
+	if tab := query.Get("ns"); tab != "" && tab != "all" {
+		var ok bool
+		if ns, ok = controlNamespaces[tab]; !ok {
+			writeJSON(res, http.StatusBadRequest, map[string]string{"error": "ns must be all, app or vsop"})
+			return
+		}
+	}
+	keep := func(line vsop.LogLineLog) bool {
+		return ns == "" || line.Namespace == ns
+	}
+
+	follow := query.Get("follow") == "1" || query.Get("follow") == "true"
+	var sub chan vsop.LogLineLog
+	if follow {
+		// Subscribe first so nothing is missed between the two
+		sub = vsop.LL().Subscribe()
+		defer vsop.LL().Unsubscribe(sub)
+	}
+
+	lines := make([]vsop.LogLineLog, 0, n)
+	all := vsop.LL().Lines()
+	for i := len(all) - 1; i >= 0 && len(lines) < n; i-- {
+		if keep(all[i]) {
+			lines = append(lines, all[i])
+		}
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	if !follow {
+		writeJSON(res, http.StatusOK, lines)
+		return
+	}
+
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		writeJSON(res, http.StatusInternalServerError, map[string]string{"error": "streaming unsupported"})
+		return
+	}
+	res.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(res)
+	var last uint64
+	if len(all) > 0 {
+		last = all[len(all)-1].ID
+	}
+	for _, line := range lines {
+		enc.Encode(line)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case line, ok := <-sub:
+			if !ok {
+				return
+			}
+			if line.ID <= last || !keep(line) {
+				continue
+			}
+			if err := enc.Encode(line); err != nil {
+				return
+			}
+			last = line.ID
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SamHennessy/vsop/vsop"
+	"github.com/pkg/errors"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// ctlCommand talks to the vsop running in the working directory
+var ctlCommand = cli.Command{
+	Name:  "ctl",
+	Usage: "Control the vsop running in the current working directory",
+	Subcommands: []cli.Command{
+		{
+			Name:   "build",
+			Usage:  "Kill the app and build, exits 1 when the build fails",
+			Action: ctlAction("/build"),
+		},
+		{
+			Name:   "restart",
+			Usage:  "Run / restart the app",
+			Action: ctlAction("/restart"),
+		},
+		{
+			Name:   "kill",
+			Usage:  "Kill the app",
+			Action: ctlAction("/kill"),
+		},
+		{
+			Name:   "deps",
+			Usage:  "Fetch dependencies then build",
+			Action: ctlAction("/deps"),
+		},
+		{
+			Name:   "tidy",
+			Usage:  "Run go mod tidy then build",
+			Action: ctlAction("/tidy"),
+		},
+		{
+			Name:   "test",
+			Usage:  "Run the tests, exits 1 when they fail",
+			Action: ctlAction("/test"),
+		},
+		{
+			Name:   "cover",
+			Usage:  "Write the HTML coverage report of the last test run and print its path",
+			Action: ctlCover,
+		},
+		{
+			Name:   "status",
+			Usage:  "Show the build and app state, exits 1 when the last build failed",
+			Action: ctlAction("/status"),
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "json", Usage: "print the status as JSON"},
+			},
+		},
+		{
+			Name:   "logs",
+			Usage:  "Show recent log lines",
+			Action: ctlLogs,
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "follow,f", Usage: "keep printing new lines"},
+				cli.IntFlag{Name: "lines,n", Value: controlLogLines, Usage: "how many recent lines to show"},
+				cli.StringFlag{Name: "ns", Value: "all", Usage: "all, app or vsop"},
+				cli.BoolFlag{Name: "json", Usage: "print one JSON object per line"},
+			},
+		},
+	},
+}
+
+// ctlAddr finds the control API of the running vsop, from the pid file or
+// the default socket
+func ctlAddr(c *cli.Context) (string, error) {
+	if c.GlobalIsSet("control") {
+		return c.GlobalString("control"), nil
+	}
+
+	data, err := ioutil.ReadFile(pidFile)
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(controlSocket); err == nil {
+			return controlSocket, nil
+		}
+		return "", errors.New("vsop isn't running in this directory")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	pid, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil || len(lines) < 2 {
+		return "", errors.Errorf("%s is not a vsop pid file", pidFile)
+	}
+	// Signalling the pid doesn't work on Windows, a vsop that answers is
+	// running
+	addr := strings.TrimSpace(lines[1])
+	conn, err := net.DialTimeout(controlNetwork(addr), addr, time.Second)
+	if err != nil {
+		return "", errors.Errorf("vsop isn't running in this directory (stale %s, pid %d)", pidFile, pid)
+	}
+	conn.Close()
+	return addr, nil
+}
+
+// ctlClient makes requests to the control API, addr goes in the URL for TCP
+// and is dialed directly for a unix socket
+func ctlClient(addr string) (*http.Client, string) {
+	if controlNetwork(addr) == "tcp" {
+		return &http.Client{}, "http://" + addr
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", addr)
+			},
+		},
+	}, "http://vsop"
+}
+
+func ctlRequest(c *cli.Context, method string, path string) (*http.Response, error) {
+	addr, err := ctlAddr(c)
+	if err != nil {
+		return nil, err
+	}
+	client, base := ctlClient(addr)
+	req, err := http.NewRequest(method, base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "connect to vsop")
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		var e struct{ Error string }
+		json.NewDecoder(res.Body).Decode(&e)
+		if e.Error == "" {
+			e.Error = res.Status
+		}
+		return nil, errors.New(e.Error)
+	}
+	return res, nil
+}
+
+// ctlAction posts an action, or gets the status, and prints the status
+func ctlAction(path string) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		method := http.MethodPost
+		if path == "/status" {
+			method = http.MethodGet
+		}
+		res, err := ctlRequest(c, method, path)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 2)
+		}
+		defer res.Body.Close()
+
+		var st controlStatus
+		if err := json.NewDecoder(res.Body).Decode(&st); err != nil {
+			return cli.NewExitError(errors.Wrap(err, "read status").Error(), 2)
+		}
+		if c.Bool("json") {
+			data, _ := json.MarshalIndent(st, "", "  ")
+			fmt.Println(string(data))
+		} else {
+			printStatus(os.Stdout, st)
+		}
+		if st.Failed || (path == "/test" && st.Tests != nil && !st.Tests.Ok) {
+			return cli.NewExitError("", 1)
+		}
+		return nil
+	}
+}
+
+// printStatus prints the status, build errors in quickfix format
+func printStatus(w io.Writer, st controlStatus) {
+	state := "standby"
+	switch {
+	case st.DepRunning:
+		state = "fetching dependencies"
+	case st.Building:
+		state = "building"
+	case st.Running:
+		state = fmt.Sprintf("running (pid %d)", st.Pid)
+	}
+	build := "ok"
+	if st.Failed {
+		build = "failed"
+	}
+	fmt.Fprintf(w, "build %d %s, app %s\n", st.Build, build, state)
+
+	for _, d := range st.Errors {
+		fmt.Fprintln(w, d.String())
+	}
+	for _, m := range st.ModuleErrors {
+		fmt.Fprintln(w, m.Error())
+	}
+	if st.Output != "" {
+		fmt.Fprintln(w, strings.TrimSpace(st.Output))
+	}
+
+	if t := st.Tests; t != nil {
+		result := "ok"
+		if !t.Ok {
+			result = "FAIL"
+		}
+		fmt.Fprintf(w, "tests %s, %d passed, %d failed, %d skipped\n", result, t.Passed, t.Failed, t.Skipped)
+		for _, f := range t.Failures {
+			fmt.Fprintf(w, "--- FAIL: %s %s\n%s", f.Package, f.Test, f.Output)
+		}
+		pkgs := make([]string, 0, len(t.Coverage))
+		for pkg := range t.Coverage {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Strings(pkgs)
+		dropped := make(map[string]bool)
+		for _, pkg := range t.Dropped {
+			dropped[pkg] = true
+		}
+		for _, pkg := range pkgs {
+			note := ""
+			if dropped[pkg] {
+				note = " (dropped)"
+			}
+			fmt.Fprintf(w, "coverage %s %.1f%%%s\n", pkg, t.Coverage[pkg], note)
+		}
+	}
+}
+
+func ctlCover(c *cli.Context) error {
+	res, err := ctlRequest(c, http.MethodPost, "/cover")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	defer res.Body.Close()
+
+	var report struct{ Path string }
+	if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+		return cli.NewExitError(errors.Wrap(err, "read response").Error(), 2)
+	}
+	fmt.Println(report.Path)
+	return nil
+}
+
+func ctlLogs(c *cli.Context) error {
+	query := url.Values{}
+	query.Set("n", strconv.Itoa(c.Int("lines")))
+	query.Set("ns", c.String("ns"))
+	if c.Bool("follow") {
+		query.Set("follow", "1")
+	}
+	res, err := ctlRequest(c, http.MethodGet, "/logs?"+query.Encode())
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	defer res.Body.Close()
+
+	sink := newConsoleSink(os.Stdout)
+	show := func(line vsop.LogLineLog) error {
+		if c.Bool("json") {
+			return json.NewEncoder(os.Stdout).Encode(line)
+		}
+		return sink.WriteLine(line)
+	}
+
+	dec := json.NewDecoder(res.Body)
+	if !c.Bool("follow") {
+		var lines []vsop.LogLineLog
+		if err := dec.Decode(&lines); err != nil {
+			return cli.NewExitError(errors.Wrap(err, "read logs").Error(), 2)
+		}
+		for _, line := range lines {
+			show(line)
+		}
+		return nil
+	}
+
+	for {
+		var line vsop.LogLineLog
+		if err := dec.Decode(&line); err != nil {
+			if err == io.EOF {
+				// vsop stopped
+				return nil
+			}
+			return cli.NewExitError(errors.Wrap(err, "read logs").Error(), 2)
+		}
+		if err := show(line); err != nil {
+			return nil
+		}
+	}
+}
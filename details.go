@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/SamHennessy/vsop/vsop"
+	"github.com/jroimartin/gocui"
+)
+
+// Height of the details panel, including the frame
+const detailsHeight = 12
+
+var (
+	// selecting is true while a log line is selected and the details panel
+	// is open
+	selecting = false
+	// selected is the index into rendered
+	selected = 0
+	// rendered are the log lines shown by the last render
+	rendered []vsop.LogLineLog
+)
+
+var reANSI = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// viewLines is how many lines text takes up in a wrapped view
+func viewLines(text string, width int) int {
+	if width < 1 {
+		width = 1
+	}
+	lines := 0
+	for _, l := range strings.Split(reANSI.ReplaceAllString(text, ""), "\n") {
+		n := utf8.RuneCountInString(l)
+		if n == 0 {
+			lines++
+		} else {
+			lines += (n + width - 1) / width
+		}
+	}
+	return lines
+}
+
+// showLines scrolls the view so lines start to end are visible
+func showLines(v *gocui.View, start int, end int) {
+	v.Autoscroll = false
+	ox, oy := v.Origin()
+	_, maxY := v.Size()
+	if end-oy >= maxY {
+		oy = end - maxY + 1
+	}
+	if start < oy {
+		oy = start
+	}
+	v.SetOrigin(ox, oy)
+}
+
+func clampSelection(i int) int {
+	if i >= len(rendered) {
+		i = len(rendered) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+// toggleDetails selects the newest log line and opens the details panel, or
+// closes it
+func toggleDetails(v *gocui.View) {
+	selecting = !selecting
+	if selecting {
+		selected = len(rendered) - 1
+	} else {
+		autoscroll(v)
+	}
+	renderLogs()
+}
+
+func moveSelection(dy int) {
+	selected = clampSelection(selected + dy)
+	renderLogs()
+}
+
+// layoutDetails adds or removes the details panel, returns the bottom of the
+// space left for the logs
+func layoutDetails(g *gocui.Gui, maxX int, maxY int) (int, error) {
+	if !selecting {
+		if err := g.DeleteView("details"); err != nil && err != gocui.ErrUnknownView {
+			return maxY, err
+		}
+		return maxY, nil
+	}
+
+	top := maxY - detailsHeight
+	if v, err := g.SetView("details", -1, top, maxX, maxY); err != nil {
+		if err != gocui.ErrUnknownView {
+			return maxY, err
+		}
+		v.Wrap = true
+		v.Title = " Details "
+	}
+	return top - 1, nil
+}
+
+// renderDetails shows every field of the selected line, must be called from
+// inside g.Update
+func renderDetails(g *gocui.Gui) {
+	v, err := g.View("details")
+	if err != nil || selected >= len(rendered) {
+		return
+	}
+	v.Clear()
+
+	line := rendered[selected]
+	fmt.Fprintf(v, "%s %s %s\n", line.Timestamp.Format("2006-01-02 15:04:05.000"), line.Namespace, line.Level)
+	if line.Stack {
+		fmt.Fprintln(v, renderStack(line.Message, nil))
+		return
+	}
+	if len(line.Fields) == 0 {
+		fmt.Fprintln(v, line.Message)
+		return
+	}
+	for _, k := range line.FieldKeys() {
+		value := strings.Replace(line.Fields[k], "\n", "\n    ", -1)
+		fmt.Fprintf(v, "\x1b[0;36m%s\x1b[0m: %s\n", k, value)
+	}
+}
+
+// expanded are the IDs of traces showing all their lines
+var expanded = make(map[uint64]bool)
+
+// toggleExpanded opens or collapses the selected trace
+func toggleExpanded() {
+	if selected >= len(rendered) || !rendered[selected].Stack {
+		return
+	}
+	id := rendered[selected].ID
+	if expanded[id] {
+		delete(expanded, id)
+	} else {
+		expanded[id] = true
+	}
+	trimExpanded(id)
+	renderLogs()
+}
+
+// trimExpanded forgets traces that have left memory, other than keep which
+// can be a spilled line found by the filter
+func trimExpanded(keep uint64) {
+	lines := vsop.LL().Lines()
+	if len(lines) == 0 {
+		return
+	}
+	oldest := lines[0].ID
+	for id := range expanded {
+		if id < oldest && id != keep {
+			delete(expanded, id)
+		}
+	}
+}
+
+// renderStack shows every line of a trace, frames from the project and the
+// function line above each are highlighted
+func renderStack(msg string, pattern *regexp.Regexp) string {
+	lines := strings.Split(msg, "\n")
+	ours := make([]bool, len(lines))
+	project := currentProjectPath()
+	for i, line := range lines {
+		file := vsop.StackFrameFile(line)
+		if file != "" && project != "" && strings.HasPrefix(file, project+string(filepath.Separator)) {
+			ours[i] = true
+			if i > 0 {
+				ours[i-1] = true
+			}
+		}
+	}
+
+	for i, line := range lines {
+		line = highlight(line, pattern)
+		if ours[i] {
+			line = "\x1b[1;33m" + line + "\x1b[0m"
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
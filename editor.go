@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/SamHennessy/vsop/vsop"
+	"github.com/jroimartin/gocui"
+	shellwords "github.com/mattn/go-shellwords"
+	"github.com/pkg/errors"
+)
+
+var (
+	// errSuspend stops the gui main loop so the editor can have the terminal
+	errSuspend = errors.New("suspend dashboard")
+	// editorCmd is the command template from --editor
+	editorCmd string
+	// editTarget is the build error waiting to be opened
+	editTarget *vsop.Diagnostic
+)
+
+// editBuildError opens the selected build error in the editor. When no build
+// error is selected the first error of the last failed build is selected.
+func editBuildError(g *gocui.Gui, v *gocui.View) error {
+	if selecting && selected < len(rendered) && rendered[selected].Diagnostic != nil {
+		editTarget = rendered[selected].Diagnostic
+		return errSuspend
+	}
+
+	i := firstBuildError()
+	if i < 0 {
+		logV.Info("No build errors to open")
+		return nil
+	}
+	selecting = true
+	selected = i
+	renderLogs()
+	return nil
+}
+
+// firstBuildError finds the first error of the last block of build errors in
+// the rendered logs, -1 if there are none
+func firstBuildError() int {
+	last := -1
+	for i := len(rendered) - 1; i >= 0; i-- {
+		if rendered[i].Diagnostic != nil {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return -1
+	}
+
+	first := last
+	for i := last - 1; i >= 0; i-- {
+		if rendered[i].Diagnostic != nil {
+			first = i
+		} else if !strings.HasPrefix(rendered[i].Message, "# ") {
+			// Package headers sit between errors, anything else ends the block
+			break
+		}
+	}
+	return first
+}
+
+// runEditor runs the editor for editTarget, called while the gui is down
+func runEditor() {
+	d := editTarget
+	editTarget = nil
+	if d == nil {
+		return
+	}
+
+	args, err := editorArgs(editorCmd, d)
+	if err != nil {
+		logV.Err(errors.Wrap(err, "editor"))
+		return
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		logV.Err(errors.Wrap(err, "editor"))
+	}
+}
+
+// editorArgs fills in {file}, {line} and {col} in the editor command. With no
+// template $EDITOR +{line} {file} is used, which vim, emacs and nano all
+// understand.
+func editorArgs(template string, d *vsop.Diagnostic) ([]string, error) {
+	if template == "" {
+		editor := strings.TrimSpace(os.Getenv("EDITOR"))
+		if editor == "" {
+			return nil, errors.New("set $EDITOR or --editor to open files")
+		}
+		template = editor + " +{line} {file}"
+		if name := filepath.Base(strings.Fields(editor)[0]); name == "code" || name == "code-insiders" {
+			template = editor + " -g {file}:{line}:{col}"
+		}
+	}
+
+	args, err := shellwords.Parse(template)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty editor command")
+	}
+
+	col := d.Column
+	if col < 1 {
+		col = 1
+	}
+	r := strings.NewReplacer("{file}", d.Path, "{line}", strconv.Itoa(d.Line), "{col}", strconv.Itoa(col))
+	for i := range args {
+		args[i] = r.Replace(args[i])
+	}
+	return args, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// defaultEnvFile is used when --envFile isn't given
+const defaultEnvFile = ".env"
+
+// envFiles are the absolute paths of the app's env files
+var envFiles []string
+
+// dotEnvFlags are the VSOP_* and BIN_APP_PORT values in .env, read once
+// when vsop starts
+var dotEnvFlags map[string]string
+
+// readDotEnv reads the flag values in .env. vsop's own environment is left
+// alone, so the rest of .env only reaches the app.
+func readDotEnv() {
+	vars, err := godotenv.Read(defaultEnvFile)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Loading %s: %s", defaultEnvFile, err)
+	}
+	dotEnvFlags = make(map[string]string)
+	for k, v := range vars {
+		if strings.HasPrefix(k, "VSOP_") || k == "BIN_APP_PORT" {
+			dotEnvFlags[k] = v
+		}
+	}
+}
+
+// applyDotEnvFlags sets each flag from its env var in .env, flags and env
+// vars win
+func applyDotEnvFlags(c *cli.Context, vars map[string]string) error {
+	for _, f := range c.App.Flags {
+		name := strings.TrimSpace(strings.Split(f.GetName(), ",")[0])
+		if c.GlobalIsSet(name) {
+			continue
+		}
+		value, ok := "", false
+		for _, envVar := range strings.Split(flagEnvVar(f), ",") {
+			if value, ok = vars[strings.TrimSpace(envVar)]; ok {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		values := []string{value}
+		if _, slice := f.(cli.StringSliceFlag); slice {
+			// Split like cli does for a slice flag's env var
+			values = strings.Split(value, ",")
+		}
+		for _, v := range values {
+			if err := c.GlobalSet(name, strings.TrimSpace(v)); err != nil {
+				return errors.Wrapf(err, "%s in %s", name, defaultEnvFile)
+			}
+		}
+	}
+	return nil
+}
+
+// flagEnvVar is the EnvVar of a flag, cli has no method for it
+func flagEnvVar(f cli.Flag) string {
+	v := reflect.Indirect(reflect.ValueOf(f)).FieldByName("EnvVar")
+	if !v.IsValid() {
+		return ""
+	}
+	return v.String()
+}
+
+// setEnvFiles gives the runner the env files from --envFile
+func setEnvFiles(c *cli.Context) {
+	files := c.GlobalStringSlice("envFile")
+	if len(files) == 0 {
+		files = []string{defaultEnvFile}
+	}
+
+	envFiles = nil
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil {
+			envFiles = append(envFiles, abs)
+		}
+	}
+	runner.SetEnvFiles(envFiles)
+}
+
+// watchEnvFiles watches the directories holding the env files, so they are
+// seen when created or replaced by an editor
+func watchEnvFiles() {
+	for _, file := range envFiles {
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			logV.Err(errors.Wrap(err, "watch env file"))
+		}
+	}
+}
+
+func isEnvFile(name string) bool {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+	for _, file := range envFiles {
+		if abs == file {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadEnv restarts the app when its environment has changed, only the
+// names of the changed variables are logged
+func reloadEnv() {
+	changed, err := runner.EnvChanges()
+	if err != nil {
+		logV.Err(err)
+	}
+	if len(changed) == 0 {
+		return
+	}
+	logV.Infof("App env changed: %s", strings.Join(changed, ", "))
+	restart()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/SamHennessy/vsop/vsop"
+)
+
+var (
+	// headless is true when running without the dashboard
+	headless    = false
+	colorYellow = "\x1b[33m"
+	colorGray   = "\x1b[90m"
+	colorCyan   = "\x1b[36m"
+)
+
+// consoleSink prints log lines to stdout for headless mode
+type consoleSink struct {
+	mu    sync.Mutex
+	out   io.Writer
+	color bool
+}
+
+func newConsoleSink(out *os.File) *consoleSink {
+	return &consoleSink{out: out, color: useColor(out)}
+}
+
+// useColor when writing to a terminal and NO_COLOR isn't set
+func useColor(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (s *consoleSink) WriteLine(line vsop.LogLineLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns := strings.TrimSpace(line.Namespace)
+	if ns == "" {
+		// The empty lines from pressing enter in the dashboard
+		ns = "-"
+	}
+	prefix := fmt.Sprintf("%s %s %-5s ", line.Timestamp.Format("15:04:05"), ns, line.Level)
+	msgColor := ""
+	if s.color {
+		nsColor := colorCyan
+		if line.Namespace == "A" {
+			nsColor = colorGreen
+		}
+		prefix = colorGray + line.Timestamp.Format("15:04:05") + colorReset + " " +
+			nsColor + ns + colorReset + " " +
+			fmt.Sprintf("%-5s", line.Level) + " "
+
+		switch line.Level {
+		case vsop.LogDebug:
+			msgColor = colorGray
+		case vsop.LogWarn:
+			msgColor = colorYellow
+		case vsop.LogError, vsop.LogFatal, vsop.LogPanic:
+			msgColor = colorRed
+		}
+	}
+
+	for _, l := range strings.Split(line.Message, "\n") {
+		if msgColor != "" {
+			l = msgColor + l + colorReset
+		}
+		if _, err := fmt.Fprintln(s.out, prefix+l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *consoleSink) Close() error {
+	return nil
+}
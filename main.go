@@ -9,15 +9,17 @@ import (
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/0xAX/notificator"
 	"github.com/SamHennessy/vsop/vsop"
 	"github.com/fsnotify/fsnotify"
-	_ "github.com/joho/godotenv/autoload"
 	"github.com/jroimartin/gocui"
 	shellwords "github.com/mattn/go-shellwords"
 	"github.com/pkg/errors"
@@ -31,34 +33,72 @@ var (
 	// Space logger
 	logS             vsop.LineLogNamespace
 	immediate        = false
-	buildError       error
 	colorGreen       = string([]byte{27, 91, 57, 55, 59, 51, 50, 59, 49, 109})
 	colorRed         = string([]byte{27, 91, 57, 55, 59, 51, 49, 59, 49, 109})
 	colorReset       = string([]byte{27, 91, 48, 109})
 	notifier         = notificator.New(notificator.Options{AppName: "VSOP"})
 	notifications    = false
-	building         = false
-	depRunning       = false
 	watcher          *fsnotify.Watcher
 	runner           *vsop.Runner
-	buildNow         func()
+	builder          *vsop.Builder
+	proxy            *vsop.Proxy
+	proxyConfig      *vsop.Config
+	events           *vsop.EventHub
+	buildNow         func() error
 	runNow           func()
 	killNow          func()
 	depNow           func()
+	tidyNow          func()
 	runPathWatch     func()
 	runPathStopWatch func()
 	done             chan (bool)
-	g                *gocui.Gui
-	logTab           = "all"
-	findTab          = "match"
+	// g is the running dashboard, nil while an editor has the terminal. Other
+	// goroutines only use it through updateGui
+	g       *gocui.Gui
+	guiMu   sync.Mutex
+	logTab  = "all"
+	findTab = "match"
+	// Absolute path being watched, stack frames in here are highlighted
+	projectPath string
+	// watchPath is --path
+	watchPath string
+	// watchAll is --all, any file change triggers a build
+	watchAll = false
+	// excludeDirs are not watched, joined to --path
+	excludeDirs []string
+	// debounce is how long changes have to stop before a build
+	debounce = 300 * time.Millisecond
+	// watchedDirs maps the absolute path of each watched directory to the
+	// name it was added with. watchedMu also guards projectPath, watchPath,
+	// watchAll and excludeDirs, which a config reload changes.
+	watchedDirs = make(map[string]string)
+	watchedMu   sync.Mutex
+	// ownFiles are the absolute paths of files vsop writes, which never
+	// trigger a build
+	ownFiles []string
+)
+
+// building counts the builds in progress and depRunning is 1 while the
+// dependency tool runs, both are only used through sync/atomic
+var (
+	building   int32
+	depRunning int32
 )
 
 func main() {
+	readDotEnv()
+
 	app := cli.NewApp()
 	app.Name = "vsop"
 	app.Usage = "A live reload utility for Go web applications."
 	app.Action = Run
 	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config,c",
+			Value:  configFile,
+			EnvVar: "VSOP_CONFIG",
+			Usage:  "project config file, flags and env vars win over it",
+		},
 		cli.StringFlag{
 			Name:   "laddr,l",
 			Value:  "",
@@ -111,6 +151,55 @@ func main() {
 			EnvVar: "VSOP_ALL",
 			Usage:  "reloads whenever any file changes, as opposed to reloading only on .go file change",
 		},
+		cli.DurationFlag{
+			Name:   "debounce",
+			Value:  300 * time.Millisecond,
+			EnvVar: "VSOP_DEBOUNCE",
+			Usage:  "how long file changes have to stop before a build starts",
+		},
+		cli.DurationFlag{
+			Name:   "buildWait",
+			Value:  30 * time.Second,
+			EnvVar: "VSOP_BUILD_WAIT",
+			Usage:  "how long to hold requests while a build is running",
+		},
+		cli.StringFlag{
+			Name:   "healthPath",
+			EnvVar: "VSOP_HEALTH_PATH",
+			Usage:  "HTTP path polled to know the app is ready, the port is dialed when not set",
+		},
+		cli.DurationFlag{
+			Name:   "startTimeout",
+			Value:  10 * time.Second,
+			EnvVar: "VSOP_START_TIMEOUT",
+			Usage:  "how long the app has to become ready before it is killed",
+		},
+		cli.BoolFlag{
+			Name:   "test",
+			EnvVar: "VSOP_TEST",
+			Usage:  "test mode, run go test on the affected packages after each build",
+		},
+		cli.StringFlag{
+			Name:   "testPkgs",
+			EnvVar: "VSOP_TEST_PKGS",
+			Usage:  "packages to test, e.g. \"./...\" (defaults to the packages a change affects)",
+		},
+		cli.StringFlag{
+			Name:   "testRun",
+			EnvVar: "VSOP_TEST_RUN",
+			Usage:  "only run tests matching this regex (go test -run)",
+		},
+		cli.StringFlag{
+			Name:   "testArgs",
+			EnvVar: "VSOP_TEST_ARGS",
+			Usage:  "additional go test arguments",
+		},
+		cli.StringSliceFlag{
+			Name:   "envFile,e",
+			Value:  &cli.StringSlice{},
+			EnvVar: "VSOP_ENV_FILE",
+			Usage:  "env file for the app, read on every run and watched for changes (default: .env)",
+		},
 		cli.StringFlag{
 			Name:   "buildArgs",
 			EnvVar: "VSOP_BUILD_ARGS",
@@ -126,6 +215,54 @@ func main() {
 			EnvVar: "VSOP_KEY_FILE",
 			Usage:  "TLS Certificate Key",
 		},
+		cli.BoolFlag{
+			Name:   "liveReload",
+			EnvVar: "VSOP_LIVE_RELOAD",
+			Usage:  "inject a script into HTML pages that reloads them after each successful build",
+		},
+		cli.IntFlag{
+			Name:   "logCap",
+			Value:  1000,
+			EnvVar: "VSOP_LOG_CAP",
+			Usage:  "how many log lines to keep in memory, 0 for no limit",
+		},
+		cli.StringFlag{
+			Name:   "logSpill",
+			EnvVar: "VSOP_LOG_SPILL",
+			Usage:  "file to write log lines to once they pass logCap, they can still be found with filter",
+		},
+		cli.StringFlag{
+			Name:   "logFile",
+			EnvVar: "VSOP_LOG_FILE",
+			Usage:  "write every log line to this file, the previous session's file is kept as <file>.1",
+		},
+		cli.StringFlag{
+			Name:   "logFormat",
+			Value:  "text",
+			EnvVar: "VSOP_LOG_FORMAT",
+			Usage:  "format of the log file, text or json",
+		},
+		cli.StringFlag{
+			Name:   "errorFile",
+			EnvVar: "VSOP_ERROR_FILE",
+			Usage:  "write build errors to this file in quickfix format (file:line:col: msg) for editors to load",
+		},
+		cli.StringFlag{
+			Name:   "editor",
+			EnvVar: "VSOP_EDITOR",
+			Usage:  "command to open a file at a line, e.g. \"code -g {file}:{line}:{col}\" (defaults to $EDITOR +{line} {file})",
+		},
+		cli.BoolFlag{
+			Name:   "headless",
+			EnvVar: "VSOP_HEADLESS",
+			Usage:  "no dashboard, write logs to stdout (for CI, containers or piping)",
+		},
+		cli.StringFlag{
+			Name:   "control",
+			Value:  controlSocket,
+			EnvVar: "VSOP_CONTROL",
+			Usage:  "unix socket or loopback host:port for the control API, empty to turn it off",
+		},
 		cli.BoolFlag{
 			Name:   "notifications",
 			EnvVar: "VSOP_NOTIFICATIONS",
@@ -139,6 +276,8 @@ func main() {
 			Usage:     "Run the proxy in the current working directory",
 			Action:    Run,
 		},
+		ctlCommand,
+		initCommand,
 	}
 
 	app.Run(os.Args)
@@ -146,22 +285,41 @@ func main() {
 
 // Run where all the fun starts
 func Run(c *cli.Context) {
+	if err := loadConfigFile(c); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	done = make(chan bool)
-	laddr := c.GlobalString("laddr")
-	port := c.GlobalInt("port")
 	appPort := strconv.Itoa(c.GlobalInt("appPort"))
 	immediate = c.GlobalBool("immediate")
-	keyFile := c.GlobalString("keyFile")
-	certFile := c.GlobalString("certFile")
 	notifications = c.GlobalBool("notifications")
+	debounce = c.GlobalDuration("debounce")
+
+	headless = c.GlobalBool("headless")
+	if headless {
+		vsop.LL().AddSink(newConsoleSink(os.Stdout))
+	}
 
-	go guidash()
+	editorCmd = c.GlobalString("editor")
+	setWatch(c)
 
 	logV = vsop.NewLineLogNamespace("V", nil)
 	logS = vsop.NewLineLogNamespace(" ", nil)
 
-	// Set the PORT env
-	os.Setenv("PORT", appPort)
+	vsop.LL().SetCap(c.GlobalInt("logCap"))
+	if err := vsop.LL().SetSpill(c.GlobalString("logSpill")); err != nil {
+		logV.Err(err)
+	}
+	if logFile := c.GlobalString("logFile"); logFile != "" {
+		sink, err := vsop.NewLogFileSink(logFile, c.GlobalString("logFormat"))
+		if err != nil {
+			logV.Err(err)
+		} else {
+			vsop.LL().AddSink(sink)
+			logV.Infof("Writing logs to %s", logFile)
+		}
+	}
 
 	wd, err := os.Getwd()
 	if err != nil {
@@ -177,67 +335,84 @@ func Run(c *cli.Context) {
 	if buildPath == "" {
 		buildPath = c.GlobalString("path")
 	}
-	builder := vsop.NewBuilder(buildPath, c.GlobalString("bin"), c.GlobalBool("godep"), wd, buildArgs)
+	builder = vsop.NewBuilder(buildPath, c.GlobalString("bin"), c.GlobalBool("godep"), wd, buildArgs)
+	if err := builder.SetErrorFile(c.GlobalString("errorFile")); err != nil {
+		logV.Err(err)
+	}
+	setOwnFiles(c)
+	if builder.Workspace() {
+		logV.Infof("Using go workspace at %s", builder.ModRoot())
+	} else if builder.DepTool() == vsop.DepModules {
+		logV.Infof("Using go modules at %s", builder.ModRoot())
+	} else if builder.DepTool() == vsop.DepDep {
+		logV.Info("Using dep")
+	}
+	testArgs, err := shellwords.Parse(c.GlobalString("testArgs"))
+	if err != nil {
+		logV.Fatal(err.Error())
+	}
+	testMode = c.GlobalBool("test")
+	testPkgs = c.GlobalString("testPkgs")
+	tester = vsop.NewTester(buildPath, testArgs)
+	tester.SetRun(c.GlobalString("testRun"))
+	if err := tester.SetCoverProfile(coverProfile); err != nil {
+		logV.Err(err)
+	}
+	graphDir := buildPath
+	if builder.DepTool() == vsop.DepModules && !builder.Workspace() {
+		graphDir = builder.ModRoot()
+	}
+	packageGraph = vsop.NewPackageGraph(graphDir)
+	if testMode {
+		logV.Info("Test mode, packages affected by a change are tested after each build")
+	}
+
 	runner = vsop.NewRunner(filepath.Join(wd, builder.Binary()), logV, c.Args()...)
+	runner.SetEnvVar("PORT", appPort)
+	runner.SetEnvDefaults(projectConfig.Env)
+	setEnvFiles(c)
+
+	events = vsop.NewEventHub()
+	builder.SetEvents(events)
+	runner.SetEvents(events)
+	tester.SetEvents(events)
+
+	ts := false
+	fl := vsop.LogInfo
+	appLog := vsop.NewLineLogNamespace("A", &vsop.LogLineConfig{
+		Timestamp:   &ts,
+		LevelFilter: &fl,
+	})
 
 	r, w := io.Pipe()
 	runner.SetWriter(w)
+	go scanApp(r, vsop.LogInfo, appLog)
 
-	go func() {
-		ts := false
-		fl := vsop.LogInfo
-		config := &vsop.LogLineConfig{
-			Timestamp:   &ts,
-			LevelFilter: &fl,
-		}
-		appLog := vsop.NewLineLogNamespace("A", config)
-
-		for true {
-			scanner := bufio.NewScanner(r)
-			for scanner.Scan() {
-				appLog.Info(scanner.Text())
-			}
-			if err := scanner.Err(); err != nil {
-				if err != io.EOF {
-					logV.Err(errors.Wrap(err, "app stream scanner"))
-				}
-			}
-		}
-
-		logV.Debug("App reader done\n")
-	}()
-
-	proxy := vsop.NewProxy(builder, runner)
+	// stderr is a warning unless the line says otherwise
+	er, ew := io.Pipe()
+	runner.SetErrWriter(ew)
+	go scanApp(er, vsop.LogWarn, appLog)
 
-	config := &vsop.Config{
-		Laddr:    laddr,
-		Port:     port,
-		ProxyTo:  "http://localhost:" + appPort,
-		KeyFile:  keyFile,
-		CertFile: certFile,
-	}
-
-	err = proxy.Run(config, logV)
+	proxyConfig = newProxyConfig(c, "http://localhost:"+appPort)
+	proxy, err = startProxy(proxyConfig)
 	if err != nil {
 		logV.Fatal(err.Error())
 	}
 
-	if laddr != "" {
-		logV.Infof("Proxy listening at %s:%d", laddr, port)
-	} else {
-		logV.Infof("Proxy listening on port %d", port)
-	}
-
 	shutdown(runner)
 
 	// build right now
-	buildNow = func() {
-		building = true
-		build(builder, runner, logV)
-		building = false
+	buildNow = func() error {
+		atomic.AddInt32(&building, 1)
+		defer atomic.AddInt32(&building, -1)
+		return build(builder, runner, logV)
 	}
 
 	runNow = func() {
+		if err := vsop.RunHooks("preRun", projectConfig.Hooks.PreRun, ".", hookEnv(runner), logV); err != nil {
+			logV.Err(err)
+			return
+		}
 		logV.Info("Run app")
 		_, err := runner.Run()
 		if err != nil {
@@ -252,16 +427,25 @@ func Run(c *cli.Context) {
 		logV.Info("Killed app")
 	}
 	depNow = func() {
-		logV.Info("Run dep ensure")
-		err := builder.DepEnsure()
+		logV.Infof("Fetch dependencies (%s)", builder.DepTool())
+		err := builder.Deps()
 		if err != nil {
+			logModuleErrors(builder, logV)
 			logV.Err(err)
 		} else {
-			logV.Info("Done dep ensure")
+			logV.Info("Done fetching dependencies")
+		}
+	}
+	tidyNow = func() {
+		logV.Info("Run go mod tidy")
+		err := builder.ModTidy()
+		if err != nil {
+			logModuleErrors(builder, logV)
+			logV.Err(err)
+		} else {
+			logV.Info("Done go mod tidy")
 		}
 	}
-
-	buildNow()
 
 	// Watch sub folders
 
@@ -275,8 +459,9 @@ func Run(c *cli.Context) {
 	// starting at the root of the project, walk each file/directory searching for
 	// directories
 	runPathWatch = func() {
-		logV.Debugf("Starting watcher, walking all subfolders of %v", c.GlobalString("path"))
-		if err := filepath.Walk(c.GlobalString("path"), watchDir); err != nil {
+		path, _ := currentWatch()
+		logV.Debugf("Starting watcher, walking all subfolders of %v", path)
+		if err := filepath.Walk(path, watchDir); err != nil {
 			logV.Err(errors.Wrap(err, "Watcher"))
 		} else {
 			logV.Debug("Watcher ready")
@@ -284,38 +469,100 @@ func Run(c *cli.Context) {
 	}
 	runPathStopWatch = func() {
 		logV.Debug("Stopping watcher")
-		if err := filepath.Walk(c.GlobalString("path"), watchDirStop); err != nil {
+		path, _ := currentWatch()
+		if err := filepath.Walk(path, watchDirStop); err != nil {
 			logV.Err(errors.Wrap(err, "Watcher"))
 		} else {
 			logV.Debug("Watcher stopped")
 		}
 
 	}
-	go runPathWatch()
+
+	// The dashboard and control API can run any action, they wait for
+	// everything the actions use to be set up. The dashboard shows the lines
+	// logged so far when it starts.
+	if !headless {
+		go guidash()
+	}
+
+	if addr := c.GlobalString("control"); addr != "" {
+		if err := startControl(addr); err != nil {
+			logV.Err(errors.Wrap(err, "control API"))
+		}
+	}
+
+	buildNow()
+
+	go func() {
+		runPathWatch()
+		watchConfigFile()
+		watchEnvFiles()
+	}()
 
 	// file watcher
 	go func() {
-		lastBuild := time.Now()
+		// Editors write a file more than once when saving and a checkout
+		// changes many files at once, build once the changes stop
+		changes := make(map[string]bool)
+		batch := time.NewTimer(time.Hour)
+		batch.Stop()
+		reload := time.NewTimer(time.Hour)
+		reload.Stop()
+		envReload := time.NewTimer(time.Hour)
+		envReload.Stop()
+		// moved are directories renamed since the last build, the files that
+		// were in them can't be listed any more
+		var moved []string
 		for {
 			select {
 			// watch for events
 			case event := <-watcher.Events:
-				if event.Op == fsnotify.Write && strings.HasSuffix(event.Name, ".go") {
-					td := time.Now().Sub(lastBuild)
-					if td.Seconds() > 1 {
-						runner.Kill()
-						// Wait for any post save hooks to run
-						time.Sleep(250 * time.Millisecond)
-						buildNow()
-						lastBuild = time.Now()
+				name := changedPath(event.Name)
+				switch {
+				case event.Name == "":
+					// A watch that was just removed can still report its
+					// directory moving, without a name
+				case isConfigFile(name):
+					reload.Reset(200 * time.Millisecond)
+				case isEnvFile(name):
+					envReload.Reset(200 * time.Millisecond)
+				case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && isWatchedDir(name):
+					// The directory's own watches are gone or point at the
+					// old name, files deleted from it have their own events
+					unwatchDir(name)
+					if event.Op&fsnotify.Rename != 0 {
+						moved = append(moved, name)
+						batch.Reset(debounce)
+					}
+				case event.Op&fsnotify.Create != 0 && isDir(name):
+					if isExcluded(name) {
+						break
 					}
-				} else if event.Op == fsnotify.Create {
-					info, err := os.Stat(event.Name)
-					if err == nil && info.IsDir() {
-						watcher.Add(event.Name)
+					// A directory moved or checked out in already has files
+					for _, file := range addWatch(name) {
+						changes[file] = true
+						batch.Reset(debounce)
 					}
+				case event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 &&
+					!isOwnFile(name) && triggers(name):
+					changes[name] = true
+					batch.Reset(debounce)
 				}
 
+			case <-batch.C:
+				files := make([]string, 0, len(changes))
+				for name := range changes {
+					files = append(files, name)
+				}
+				sort.Strings(files)
+				changes = make(map[string]bool)
+				buildChanged(files, moved)
+				moved = nil
+			case <-reload.C:
+				reloadConfig(c.App)
+			case <-envReload.C:
+				reloadEnv()
+
 			// watch for errors
 			case err := <-watcher.Errors:
 				logV.Err(err)
@@ -326,29 +573,90 @@ func Run(c *cli.Context) {
 	<-done
 }
 
-func build(builder *vsop.Builder, runner *vsop.Runner, logger vsop.LineLogNamespace) {
-	logger.Info("Building...")
+// scanApp logs app output a line at a time, guessing the level of each line
+func scanApp(r io.Reader, fallback vsop.LogLineLevel, appLog vsop.LineLogNamespace) {
+	lines := make(chan string, 64)
+	grouper := vsop.NewStackGrouper(func(group []string, stack bool) {
+		if stack {
+			appLog.Add(vsop.LogLineLog{
+				Message: strings.Join(group, "\n"),
+				Level:   vsop.DetectLevel(group[0], fallback),
+				Stack:   true,
+			})
+			return
+		}
+		fields, _ := vsop.ParseFields(group[0])
+		appLog.PrintFields(vsop.DetectLevel(group[0], fallback), group[0], fields)
+	})
+	go grouper.Run(lines)
+
+	for true {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		if err := scanner.Err(); err != nil {
+			if err != io.EOF {
+				logV.Err(errors.Wrap(err, "app stream scanner"))
+			}
+		}
+	}
+
+	logV.Debug("App reader done\n")
+}
 
-	buildStart := time.Now()
-	err := builder.Build()
+// build runs the preBuild hooks and builds the app, the error is nil if
+// the build succeeded
+func build(builder *vsop.Builder, runner *vsop.Runner, logger vsop.LineLogNamespace) error {
+	// Requests are held from the start, so the old binary isn't started
+	// again while it's killed and the hooks run
+	var hookErr error
+	var buildStart time.Time
+	err := builder.Build(func() error {
+		runner.Kill()
+		if hookErr = vsop.RunHooks("preBuild", projectConfig.Hooks.PreBuild, ".", hookEnv(runner), logger); hookErr != nil {
+			return hookErr
+		}
+		logger.Info("Building...")
+		buildStart = time.Now()
+		return nil
+	})
+	if hookErr != nil {
+		logger.Err(hookErr)
+		return hookErr
+	}
 	buildTime := time.Now().Sub(buildStart)
 	if err != nil {
-		buildError = err
 		logger.Error("Build failed")
-		buildErrors := strings.Split(builder.Errors(), "\n")
-		for i := 0; i < len(buildErrors); i++ {
-			logger.Error(buildErrors[i])
+		buildErrors := strings.Split(strings.TrimSpace(builder.Errors()), "\n")
+		diags := builder.Diagnostics()
+		logged := logModuleErrors(builder, logger)
+		if len(diags) > 0 {
+			logDiagnostics(diags, logger)
+		} else if logged == 0 {
+			for i := 0; i < len(buildErrors); i++ {
+				logger.Error(buildErrors[i])
+			}
 		}
 		if notifications {
+			summary := buildErrors[len(buildErrors)-1]
+			if len(diags) > 0 {
+				summary = diags[0].String()
+				if len(diags) > 1 {
+					summary += fmt.Sprintf(" (+%d more)", len(diags)-1)
+				}
+			}
 			go func() {
-				if err := notifier.Push("Build FAILED!", buildErrors[1], "", notificator.UR_CRITICAL); err != nil {
+				if err := notifier.Push("Build FAILED!", summary, "", notificator.UR_CRITICAL); err != nil {
 					logger.Err(errors.Wrap(err, "Notification send failed"))
 				}
 			}()
 		}
 	} else {
-		buildError = nil
 		logger.Info("Build finished")
+		if err := vsop.RunHooks("postBuild", projectConfig.Hooks.PostBuild, ".", hookEnv(runner), logger); err != nil {
+			logger.Err(err)
+		}
 		if immediate {
 			runNow()
 		}
@@ -360,6 +668,135 @@ func build(builder *vsop.Builder, runner *vsop.Runner, logger vsop.LineLogNamesp
 			}()
 		}
 	}
+	return err
+}
+
+// hookEnv is the app's environment, hooks see the same variables as the app
+func hookEnv(runner *vsop.Runner) []string {
+	env, err := runner.Environ()
+	if err != nil {
+		logV.Err(err)
+	}
+	return env
+}
+
+// logDiagnostics logs build diagnostics grouped under their package
+func logDiagnostics(diags []vsop.Diagnostic, logger vsop.LineLogNamespace) {
+	pkg := ""
+	for i, d := range diags {
+		if i == 0 || d.Package != pkg {
+			pkg = d.Package
+			if pkg != "" {
+				logger.Error("# " + pkg)
+			}
+		}
+		level := vsop.LogError
+		if d.Severity == vsop.SeverityWarning {
+			level = vsop.LogWarn
+		}
+		d := d
+		logger.Add(vsop.LogLineLog{Message: d.String(), Level: level, Diagnostic: &d})
+	}
+}
+
+// logModuleErrors logs each module resolution failure on its own line and
+// returns how many there were
+func logModuleErrors(builder *vsop.Builder, logger vsop.LineLogNamespace) int {
+	mods := builder.ModuleErrors()
+	for _, m := range mods {
+		logger.Error("Module error: " + m.Error())
+		if m.Fix != "" {
+			logger.Error("  fix: " + m.Fix)
+		}
+	}
+	return len(mods)
+}
+
+// rebuild stops the app and builds it again
+func rebuild() {
+	buildNow()
+}
+
+// restart runs the app, stopping it first if it's running
+func restart() {
+	killNow()
+	runNow()
+}
+
+// startDeps marks the dependency tool as running, false if it already is
+func startDeps() bool {
+	if !atomic.CompareAndSwapInt32(&depRunning, 0, 1) {
+		logV.Info("Dependencies are already being fetched")
+		return false
+	}
+	return true
+}
+
+func depsRunning() bool {
+	return atomic.LoadInt32(&depRunning) == 1
+}
+
+func isBuilding() bool {
+	return atomic.LoadInt32(&building) > 0
+}
+
+// fetchDeps downloads dependencies then builds, the watcher is off while
+// the dependency tool writes files. False if it was already running.
+func fetchDeps() bool {
+	if !startDeps() {
+		return false
+	}
+	defer atomic.StoreInt32(&depRunning, 0)
+	runPathStopWatch()
+	depNow()
+	runPathWatch()
+	buildNow()
+	return true
+}
+
+// tidy runs go mod tidy then builds, false if the dependency tool was already
+// running
+func tidy() bool {
+	if !startDeps() {
+		return false
+	}
+	defer atomic.StoreInt32(&depRunning, 0)
+	tidyNow()
+	buildNow()
+	return true
+}
+
+// newProxyConfig from the proxy flags, proxyTo is the app's address
+func newProxyConfig(c *cli.Context, proxyTo string) *vsop.Config {
+	return &vsop.Config{
+		Laddr:    c.GlobalString("laddr"),
+		Port:     c.GlobalInt("port"),
+		ProxyTo:  proxyTo,
+		KeyFile:  c.GlobalString("keyFile"),
+		CertFile: c.GlobalString("certFile"),
+
+		LiveReload: c.GlobalBool("liveReload"),
+		BuildWait:  c.GlobalDuration("buildWait"),
+
+		HealthPath:   c.GlobalString("healthPath"),
+		StartTimeout: c.GlobalDuration("startTimeout"),
+	}
+}
+
+// startProxy listens with a new proxy
+func startProxy(config *vsop.Config) (*vsop.Proxy, error) {
+	p := vsop.NewProxy(builder, runner)
+	p.SetEvents(events)
+	if err := p.Run(config, logV); err != nil {
+		return nil, err
+	}
+
+	if config.Laddr != "" {
+		logV.Infof("Proxy listening at %s:%d", config.Laddr, config.Port)
+	} else {
+		logV.Infof("Proxy listening on port %d", config.Port)
+	}
+	return p, nil
 }
 
 func shutdown(runner *vsop.Runner) {
@@ -367,22 +804,48 @@ func shutdown(runner *vsop.Runner) {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		s := <-c
-		log.Println("Got signal: ", s)
+		logV.Infof("Got signal: %s", s)
 		err := runner.Kill()
 		if err != nil {
-			log.Print("Error killing: ", err)
+			logV.Err(errors.Wrap(err, "killing app"))
 		}
-		os.Exit(1)
+		stopControl()
+		vsop.LL().Close()
+		guiMu.Lock()
+		if !headless && g != nil {
+			// Give the terminal back
+			g.Close()
+		}
+		guiMu.Unlock()
+		code := 1
+		if sig, ok := s.(syscall.Signal); ok {
+			code = 128 + int(sig)
+		}
+		os.Exit(code)
 	}()
 }
 
 // watchDir gets run as a walk func, searching for directories to add watchers to
 func watchDir(path string, fi os.FileInfo, err error) error {
+	if err != nil {
+		// Removed while walking
+		return nil
+	}
 
 	// since fsnotify can watch all the files in a directory, watchers only need
 	// to be added to each nested directory
 	if fi.Mode().IsDir() {
-		return watcher.Add(path)
+		if isExcluded(path) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return err
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			watchedMu.Lock()
+			watchedDirs[abs] = path
+			watchedMu.Unlock()
+		}
 	}
 
 	return nil
@@ -390,44 +853,282 @@ func watchDir(path string, fi os.FileInfo, err error) error {
 
 // watchDirStop
 func watchDirStop(path string, fi os.FileInfo, err error) error {
+	if err != nil {
+		return nil
+	}
 	if fi.Mode().IsDir() {
+		if isExcluded(path) {
+			return filepath.SkipDir
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil
+		}
+		watchedMu.Lock()
+		_, watched := watchedDirs[abs]
+		delete(watchedDirs, abs)
+		watchedMu.Unlock()
+		if !watched {
+			// Stopped before the watch started
+			return nil
+		}
 		return watcher.Remove(path)
 	}
 	return nil
 }
 
+// addWatch watches a new directory and the ones under it, returns the files
+// in them that trigger a build
+func addWatch(dir string) []string {
+	var files []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return watchDir(path, fi, err)
+		}
+		if !isOwnFile(path) && triggers(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		logV.Err(errors.Wrap(err, "watch "+dir))
+	}
+	return files
+}
+
+// unwatchDir forgets a directory that was removed or renamed, and every
+// watched directory under it
+func unwatchDir(dir string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	watchedMu.Lock()
+	defer watchedMu.Unlock()
+	for path, name := range watchedDirs {
+		if path == abs || strings.HasPrefix(path, abs+string(filepath.Separator)) {
+			// The watch is already gone when the directory was deleted
+			watcher.Remove(name)
+			delete(watchedDirs, path)
+		}
+	}
+}
+
+// isWatchedDir is true for a directory that has a watch
+func isWatchedDir(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	watchedMu.Lock()
+	defer watchedMu.Unlock()
+	_, ok := watchedDirs[abs]
+	return ok
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// setWatch sets what's watched from --path, --excludeDir and --all
+func setWatch(c *cli.Context) {
+	path := c.GlobalString("path")
+	var excluded []string
+	for _, dir := range c.GlobalStringSlice("excludeDir") {
+		excluded = append(excluded, filepath.Join(path, dir))
+	}
+
+	watchedMu.Lock()
+	defer watchedMu.Unlock()
+	watchPath = path
+	if p, err := filepath.Abs(path); err == nil {
+		projectPath = p
+	}
+	watchAll = c.GlobalBool("all")
+	excludeDirs = excluded
+}
+
+// currentWatch is --path and --all
+func currentWatch() (string, bool) {
+	watchedMu.Lock()
+	defer watchedMu.Unlock()
+	return watchPath, watchAll
+}
+
+// currentProjectPath is the absolute --path
+func currentProjectPath() string {
+	watchedMu.Lock()
+	defer watchedMu.Unlock()
+	return projectPath
+}
+
+// triggers is true for a file whose change starts a build
+func triggers(name string) bool {
+	path, all := currentWatch()
+	return projectConfig.Watch.Triggers(name, path, all)
+}
+
+// buildChanged kills the app and builds once for a batch of changed files
+// and moved directories
+func buildChanged(files []string, moved []string) {
+	if len(files) > 0 {
+		logV.Info("Files changed: " + strings.Join(files, ", "))
+	}
+	if len(moved) > 0 {
+		logV.Info("Directories moved: " + strings.Join(moved, ", "))
+	}
+	err := buildNow()
+	go changedPackages(files, len(moved) > 0, err == nil)
+}
+
+// changedPath is name relative to the working directory, the same file can be
+// named relative or absolute depending on which watch saw it
+func changedPath(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return abs
+}
+
+// setOwnFiles works out which files vsop writes itself
+func setOwnFiles(c *cli.Context) {
+	files := []string{
+		builder.Binary(),
+		builder.ErrorFile(),
+		c.GlobalString("logFile"),
+		c.GlobalString("logSpill"),
+		coverProfile,
+		coverReport,
+	}
+	if logFile := c.GlobalString("logFile"); logFile != "" {
+		files = append(files, vsop.RotatedLogFiles(logFile)...)
+	}
+
+	ownFiles = nil
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(f); err == nil {
+			ownFiles = append(ownFiles, abs)
+		}
+	}
+}
+
+// isOwnFile is true for a file vsop writes, so --all doesn't build forever
+func isOwnFile(name string) bool {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+	for _, f := range ownFiles {
+		if f == abs {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcluded is true for directories under --excludeDir
+func isExcluded(path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	watchedMu.Lock()
+	excluded := excludeDirs
+	watchedMu.Unlock()
+	for _, dir := range excluded {
+		if dir, err = filepath.Abs(dir); err != nil {
+			continue
+		}
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
 type logItem struct {
 	message string
 	typ     string
 }
 
 func guidash() {
-	var err error
-	g, err = gocui.NewGui(gocui.Output256)
+	// The dashboard is torn down while an editor has the terminal, then
+	// started again
+	for {
+		err := runGui()
+		if err != errSuspend {
+			if err != nil && err != gocui.ErrQuit {
+				log.Panicln(err)
+			}
+			return
+		}
+		runEditor()
+	}
+}
+
+func runGui() error {
+	gui, err := gocui.NewGui(gocui.Output256)
 	if err != nil {
 		log.Panicln(err)
 	}
-	defer g.Close()
 
-	g.SetManagerFunc(layout)
+	gui.SetManagerFunc(layout)
 
-	if err := initGlobalKeybindings(g); err != nil {
+	if err := initGlobalKeybindings(gui); err != nil {
 		log.Panicln(err)
 	}
 
-	// Log loop
-	go watchLogs()
-	// Status
+	guiMu.Lock()
+	g = gui
+	guiMu.Unlock()
+
+	// The log and status loops only live as long as this gui, they're
+	// stopped before it's closed
+	stop := make(chan struct{})
+	var loops sync.WaitGroup
+	loops.Add(2)
 	go func() {
-		ticker := time.NewTicker(time.Millisecond * 500)
-		for range ticker.C {
-			updateStatus()
-		}
+		defer loops.Done()
+		watchLogs(stop)
+	}()
+	go func() {
+		defer loops.Done()
+		watchStatus(gui, stop)
+	}()
+	defer func() {
+		close(stop)
+		loops.Wait()
+		guiMu.Lock()
+		g = nil
+		gui.Close()
+		guiMu.Unlock()
 	}()
 
+	// Anything queued on the old gui is gone
+	atomic.StoreInt32(&rendering, 0)
+	renderLogs()
+
 	// Blocking
-	if err := g.MainLoop(); err != nil && err != gocui.ErrQuit {
-		log.Panicln(err)
+	return gui.MainLoop()
+}
+
+// updateGui queues f on the running dashboard, it's dropped while there isn't
+// one
+func updateGui(f func(*gocui.Gui) error) {
+	guiMu.Lock()
+	defer guiMu.Unlock()
+	if g != nil {
+		g.Update(f)
 	}
 }
 
@@ -458,7 +1159,16 @@ func layout(g *gocui.Gui) error {
 		v.Title = "[Find] Filter "
 	}
 
-	if v, err := g.SetView("logs", -1, 3, maxX, maxY); err != nil {
+	logsBottom, err := layoutDetails(g, maxX, maxY)
+	if err != nil {
+		return err
+	}
+	logsBottom, err = layoutTests(g, maxX, logsBottom)
+	if err != nil {
+		return err
+	}
+
+	if v, err := g.SetView("logs", -1, 3, maxX, logsBottom); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
@@ -477,11 +1187,17 @@ func initGlobalKeybindings(g *gocui.Gui) error {
 	if err := g.SetKeybinding("", gocui.KeyCtrlC, gocui.ModNone, quit); err != nil {
 		return err
 	}
+	// open a build error in the editor
+	if err := g.SetKeybinding("logs", gocui.KeyCtrlE, gocui.ModNone, editBuildError); err != nil {
+		return err
+	}
 	return nil
 }
 
 func quit(g *gocui.Gui, v *gocui.View) error {
 	killNow()
+	stopControl()
+	vsop.LL().Close()
 	done <- true
 	return gocui.ErrQuit
 }
@@ -515,33 +1231,62 @@ func autoscroll(v *gocui.View) error {
 	return nil
 }
 
-func watchLogs() {
-	lastUpdate := time.Now()
+func watchLogs(stop chan struct{}) {
+	lines := vsop.LL().Subscribe()
+	defer vsop.LL().Unsubscribe(lines)
 	for {
-		logs := vsop.LL().Logs
-		if len(logs) > 0 {
-			if lastUpdate.Before(logs[len(logs)-1].Timestamp) {
-				lastUpdate = logs[len(logs)-1].Timestamp
-				renderLogs()
+		select {
+		case <-stop:
+			return
+		case <-lines:
+		}
+		// A burst of lines only needs one render
+		for drained := false; !drained; {
+			select {
+			case <-lines:
+			default:
+				drained = true
 			}
 		}
-		time.Sleep(time.Millisecond * 500)
+		renderLogs()
 	}
 }
 
-// TODO: is this helping?
-var rendering = false
+func watchStatus(gui *gocui.Gui, stop chan struct{}) {
+	ticker := time.NewTicker(time.Millisecond * 500)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			updateStatus(gui)
+		}
+	}
+}
+
+// Only one render is queued at a time, anything asking for a render while one
+// is running gets another one after it
+var (
+	rendering   int32
+	renderAgain int32
+)
 
 func renderLogs() {
-	// TODO: ?
-	if rendering == true {
-		//logV.Debug("Render flood")
+	if !atomic.CompareAndSwapInt32(&rendering, 0, 1) {
+		atomic.StoreInt32(&renderAgain, 1)
 		return
 	}
-	rendering = true
 
-	logs := vsop.LL().Logs
-	g.Update(func(g *gocui.Gui) error {
+	logs := vsop.LL().Lines()
+	updateGui(func(g *gocui.Gui) error {
+		defer func() {
+			atomic.StoreInt32(&rendering, 0)
+			if atomic.SwapInt32(&renderAgain, 0) == 1 {
+				go renderLogs()
+			}
+		}()
+
 		v, err := g.View("logs")
 		if err != nil {
 			logV.Err(errors.Wrap(err, "watch logs getting log view"))
@@ -556,44 +1301,55 @@ func renderLogs() {
 			v.Title = " Logs [All] App  VSOP  "
 		}
 
-		// TODO:
-		// if v.Autoscroll && len(logs) > 500 {
-		// 	logs = logs[len(logs)-500:]
-		// }
-
-		for i := 0; i < len(logs); i++ {
-
-			lMsg := logs[i].Message
-			findV, err := g.View("find")
-			if err != nil {
-				// TODO
-			}
-			find := strings.TrimSpace(findV.Buffer())
-			if find != "" {
-				pattern := regexp.MustCompile(fmt.Sprintf("(?i)%v", find))
-				result := ""
-				cur := 0
-				for _, submatches := range pattern.FindAllStringSubmatchIndex(lMsg, -1) {
-					result += lMsg[cur:submatches[0]]
-					result += fmt.Sprintf("\x1b[0;43m%v\x1b[0;39m", lMsg[submatches[0]:submatches[1]])
-					cur = submatches[1]
-				}
-				result += lMsg[cur:]
-				if result == lMsg && findTab == "filter" {
-					continue
+		var pattern *regexp.Regexp
+		if findV, err := g.View("find"); err == nil {
+			if find := strings.TrimSpace(findV.Buffer()); find != "" {
+				pattern, err = regexp.Compile("(?i)" + find)
+				if err != nil {
+					// Half typed regex, match it literally
+					pattern = regexp.MustCompile("(?i)" + regexp.QuoteMeta(find))
 				}
-				lMsg = result
 			}
+		}
+		if pattern != nil && findTab == "filter" {
+			// Filtering also searches lines spilled to disk, it matches
+			// the raw line so hidden fields are found too
+			found, err := vsop.LL().Search(pattern)
+			if err != nil {
+				logV.Err(err)
+			}
+			logs = found
+		}
 
+		shown := logs[:0:0]
+		for i := 0; i < len(logs); i++ {
 			if logs[i].Namespace == "V" && logTab == "app" {
 				continue
 			}
 			if logs[i].Namespace == "A" && logTab == "vsop" {
 				continue
 			}
+			shown = append(shown, logs[i])
+		}
+		rendered = shown
+		if selecting {
+			selected = clampSelection(selected)
+		}
+
+		width, _ := v.Size()
+		line := 0
+		selStart, selEnd := -1, -1
+		for i := 0; i < len(shown); i++ {
+
+			var lMsg string
+			if shown[i].Stack && expanded[shown[i].ID] {
+				lMsg = renderStack(shown[i].Message, pattern)
+			} else {
+				lMsg = highlight(shown[i].Summary(), pattern)
+			}
 
 			level := "?"
-			switch logs[i].Level {
+			switch shown[i].Level {
 			case vsop.LogDebug:
 				level = "D"
 			case vsop.LogInfo:
@@ -607,23 +1363,49 @@ func renderLogs() {
 			case vsop.LogPanic:
 				level = "P"
 			}
-			fmt.Fprintf(
-				v,
-				"[%s %s %s] %s\n",
-				logs[i].Timestamp.Format("15:04:05"),
-				logs[i].Namespace,
+			text := fmt.Sprintf(
+				"[%s %s %s] %s",
+				shown[i].Timestamp.Format("15:04:05"),
+				shown[i].Namespace,
 				level,
 				lMsg,
 			)
+			if selecting && i == selected {
+				selStart = line
+				text = "\x1b[7m" + text + "\x1b[0m"
+			}
+			line += viewLines(text, width)
+			if selecting && i == selected {
+				selEnd = line - 1
+			}
+			fmt.Fprintln(v, text)
+		}
+
+		if selecting && selStart >= 0 {
+			showLines(v, selStart, selEnd)
+			renderDetails(g)
 		}
-		// TODO: ?
-		rendering = false
 		return nil
 	})
 }
 
-func updateStatus() {
-	g.Update(func(g *gocui.Gui) error {
+// highlight the parts of msg that match the find pattern
+func highlight(msg string, pattern *regexp.Regexp) string {
+	if pattern == nil {
+		return msg
+	}
+	result := ""
+	cur := 0
+	for _, submatches := range pattern.FindAllStringSubmatchIndex(msg, -1) {
+		result += msg[cur:submatches[0]]
+		result += fmt.Sprintf("\x1b[0;43m%v\x1b[0;39m", msg[submatches[0]:submatches[1]])
+		cur = submatches[1]
+	}
+	return result + msg[cur:]
+}
+
+func updateStatus(gui *gocui.Gui) {
+	gui.Update(func(g *gocui.Gui) error {
 		v, err := g.View("status")
 		if err != nil {
 			logV.Err(errors.Wrap(err, "watch logs getting log view"))
@@ -632,10 +1414,10 @@ func updateStatus() {
 		v.Clear()
 		msg := ""
 		v.BgColor = gocui.ColorBlack
-		if depRunning {
-			msg = "dep Running"
+		if depsRunning() {
+			msg = "Deps Running"
 			v.BgColor = gocui.ColorYellow
-		} else if building {
+		} else if isBuilding() {
 			v.BgColor = gocui.ColorYellow
 			msg = "Building"
 		} else if runner.IsRunning() {
@@ -646,6 +1428,7 @@ func updateStatus() {
 			msg = "Standby"
 		}
 		fmt.Fprint(v, msg)
+		updateTestStatus(g)
 
 		return nil
 	})
@@ -693,31 +1476,42 @@ func findEditor(v *gocui.View, key gocui.Key, ch rune, mod gocui.Modifier) {
 }
 func logEditor(v *gocui.View, key gocui.Key, ch rune, mod gocui.Modifier) {
 	switch {
+	case key == gocui.KeyEnter && selecting: // expand/collapse a trace
+		toggleExpanded()
 	case key == gocui.KeyEnter:
 		logS.Info("")
 		autoscroll(v)
 		renderLogs()
+	case key == gocui.KeyArrowDown && selecting:
+		moveSelection(1)
+	case key == gocui.KeyArrowUp && selecting:
+		moveSelection(-1)
 	case key == gocui.KeyArrowDown:
 		scrollView(v, 1)
 	case key == gocui.KeyArrowUp:
 		scrollView(v, -1)
+	case key == gocui.KeyCtrlO: // select a line and show its details
+		toggleDetails(v)
 	case key == gocui.KeyEnd: // autoscroll
 		autoscroll(v)
 	case key == gocui.KeyCtrlB: // build
-		killNow()
-		buildNow()
-	case key == gocui.KeyCtrlD: // dep ensure
-		depRunning = true
-		runPathStopWatch()
-		depNow()
-		runPathWatch()
-		buildNow()
-		depRunning = false
+		rebuild()
+	case key == gocui.KeyCtrlD: // dep ensure / go mod download
+		fetchDeps()
+	case key == gocui.KeyCtrlT: // go mod tidy
+		tidy()
 	case key == gocui.KeyCtrlR: // run/restart app
-		killNow()
-		runNow()
+		restart()
 	case key == gocui.KeyCtrlK: // kill/stop app
 		killNow()
+	case key == gocui.KeyCtrlG: // go test
+		go testAll()
+	case key == gocui.KeyCtrlP: // test results panel
+		toggleTests()
+	case key == gocui.KeyCtrlV: // coverage report
+		if err := writeCoverReport(); err != nil {
+			logV.Err(err)
+		}
 	case key == gocui.KeyTab:
 		if logTab == "all" {
 			logTab = "app"
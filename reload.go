@@ -0,0 +1,267 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/SamHennessy/vsop/vsop"
+	shellwords "github.com/mattn/go-shellwords"
+	"github.com/pkg/errors"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Settings a config reload can apply without restarting vsop, grouped by
+// what has to happen when they change
+var (
+	proxySettings = []string{"laddr", "port", "certFile", "keyFile", "liveReload", "buildWait", "healthPath", "startTimeout"}
+	watchSettings = []string{"path", "excludeDir", "all"}
+	liveSettings  = []string{"immediate", "debounce", "notifications", "editor", "logCap", "errorFile", "buildArgs", "envFile", "test", "testPkgs", "testRun"}
+)
+
+// currentFlags are the resolved flags the running settings came from
+var currentFlags *flag.FlagSet
+
+// resolveFlags works out every flag again, from the command line, env vars,
+// .env, the config file and the defaults, in that order
+func resolveFlags(app *cli.App, config *vsop.ProjectConfig) (*cli.Context, *flag.FlagSet, error) {
+	c, set, err := parseFlags(app, os.Args[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := applyDotEnvFlags(c, dotEnvFlags); err != nil {
+		return nil, nil, err
+	}
+	if err := applyConfigFlags(c, config.Flags); err != nil {
+		return nil, nil, err
+	}
+	return c, set, nil
+}
+
+// parseFlags parses args into a new set of the app's flags. A flag given by
+// its short name is copied to its long name, as cli does when it runs.
+func parseFlags(app *cli.App, args []string) (*cli.Context, *flag.FlagSet, error) {
+	set := flag.NewFlagSet(app.Name, flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	for _, f := range app.Flags {
+		if sf, ok := f.(cli.StringSliceFlag); ok {
+			// Values are appended to the default, which is shared with the
+			// flags that were parsed at startup
+			sf.Value = &cli.StringSlice{}
+			f = sf
+		}
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	given := make(map[string]bool)
+	set.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, f := range app.Flags {
+		if _, ok := f.(cli.StringSliceFlag); ok {
+			// Every name shares the one value
+			continue
+		}
+		names := strings.Split(f.GetName(), ",")
+		name := strings.TrimSpace(names[0])
+		for _, alias := range names[1:] {
+			if alias = strings.TrimSpace(alias); given[alias] && !given[name] {
+				set.Set(name, set.Lookup(alias).Value.String())
+			}
+		}
+	}
+	return cli.NewContext(app, set, nil), set, nil
+}
+
+// watchConfigFile watches the directory holding the config file, so it is
+// seen when it's created or replaced by an editor
+func watchConfigFile() {
+	if err := watcher.Add(filepath.Dir(configPath)); err != nil {
+		logV.Err(errors.Wrap(err, "watch config file"))
+	}
+}
+
+func isConfigFile(name string) bool {
+	abs, err := filepath.Abs(name)
+	return err == nil && abs == configPath
+}
+
+// reloadConfig reads the config file again and applies what changed. A file
+// that doesn't load is logged and the running settings are kept.
+func reloadConfig(app *cli.App) {
+	config, err := readConfigFile(configPath)
+	if err != nil {
+		logV.Err(errors.Wrap(err, "reload config"))
+		return
+	}
+	c, set, err := resolveFlags(app, config)
+	if err != nil {
+		logV.Err(errors.Wrap(err, "reload config "+configPath))
+		return
+	}
+
+	changed := make(map[string]bool)
+	for _, name := range c.GlobalFlagNames() {
+		old, next := currentFlags.Lookup(name), set.Lookup(name)
+		if old == nil || next == nil || old.Value.String() == next.Value.String() {
+			continue
+		}
+		changed[name] = true
+		logV.Infof("Config %s: %s -> %s", name, old.Value, next.Value)
+	}
+	watchChanged := !reflect.DeepEqual(projectConfig.Watch, config.Watch)
+	if watchChanged {
+		logV.Infof("Config watch: %+v -> %+v", projectConfig.Watch, config.Watch)
+	}
+	hooksChanged := !reflect.DeepEqual(projectConfig.Hooks, config.Hooks)
+	if hooksChanged {
+		logV.Infof("Config hooks: %+v -> %+v", projectConfig.Hooks, config.Hooks)
+	}
+	envChanged := logEnvChanges(projectConfig.Env, config.Env)
+
+	projectConfig = config
+	currentFlags = set
+	if len(changed) == 0 && !watchChanged && !hooksChanged && !envChanged {
+		logV.Debug("Config reloaded, nothing changed")
+		return
+	}
+
+	if envChanged {
+		runner.SetEnvDefaults(config.Env)
+	}
+	if anyChanged(changed, liveSettings) {
+		immediate = c.GlobalBool("immediate")
+		notifications = c.GlobalBool("notifications")
+		debounce = c.GlobalDuration("debounce")
+		editorCmd = c.GlobalString("editor")
+		vsop.LL().SetCap(c.GlobalInt("logCap"))
+		testMode = c.GlobalBool("test")
+		testPkgs = c.GlobalString("testPkgs")
+		tester.SetRun(c.GlobalString("testRun"))
+		if changed["envFile"] {
+			setEnvFiles(c)
+			watchEnvFiles()
+		}
+		if changed["errorFile"] {
+			if err := builder.SetErrorFile(c.GlobalString("errorFile")); err != nil {
+				logV.Err(err)
+			}
+			setOwnFiles(c)
+		}
+	}
+	if anyChanged(changed, proxySettings) {
+		restartProxy(newProxyConfig(c, proxyConfig.ProxyTo))
+	}
+	if anyChanged(changed, watchSettings) || watchChanged {
+		rewalk := changed["path"] || changed["excludeDir"]
+		if rewalk {
+			runPathStopWatch()
+		}
+		setWatch(c)
+		if rewalk {
+			runPathWatch()
+			watchConfigFile()
+		}
+	}
+
+	var restartVsop []string
+	for name := range changed {
+		if !inSettings(name, liveSettings) && !inSettings(name, proxySettings) && !inSettings(name, watchSettings) {
+			restartVsop = append(restartVsop, name)
+		}
+	}
+	if changed["path"] && c.GlobalString("build") == "" {
+		restartVsop = append(restartVsop, "path (build directory)")
+	}
+	if len(restartVsop) > 0 {
+		sort.Strings(restartVsop)
+		logV.Warn("Restart vsop to apply " + strings.Join(restartVsop, ", "))
+	}
+
+	if changed["buildArgs"] {
+		args, err := shellwords.Parse(c.GlobalString("buildArgs"))
+		if err != nil {
+			logV.Err(errors.Wrap(err, "buildArgs"))
+		} else {
+			builder.SetBuildArgs(args)
+			rebuild()
+			return
+		}
+	}
+	reloadEnv()
+}
+
+// restartProxy swaps the proxy for one with the new config, going back to
+// the old config if the new one can't listen
+func restartProxy(config *vsop.Config) {
+	if proxy != nil {
+		proxy.Close()
+	}
+	p, err := startProxy(config)
+	if err == nil {
+		proxy = p
+		proxyConfig = config
+		return
+	}
+
+	logV.Err(errors.Wrap(err, "restart proxy"))
+	proxy, err = startProxy(proxyConfig)
+	if err != nil {
+		logV.Err(errors.Wrap(err, "restart proxy with the previous config"))
+	}
+}
+
+// logEnvChanges logs each env var added, changed or removed, true if any were
+func logEnvChanges(old map[string]string, next map[string]string) bool {
+	keys := make([]string, 0, len(old)+len(next))
+	for k := range old {
+		keys = append(keys, k)
+	}
+	for k := range next {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	changed := false
+	for _, k := range keys {
+		before, hadBefore := old[k]
+		after, hasAfter := next[k]
+		switch {
+		case !hadBefore:
+			logV.Infof("Config env %s added: %s", k, after)
+		case !hasAfter:
+			logV.Infof("Config env %s removed", k)
+		case before != after:
+			logV.Infof("Config env %s: %s -> %s", k, before, after)
+		default:
+			continue
+		}
+		changed = true
+	}
+	return changed
+}
+
+func anyChanged(changed map[string]bool, names []string) bool {
+	for _, name := range names {
+		if changed[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func inSettings(name string, names []string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/0xAX/notificator"
+	"github.com/SamHennessy/vsop/vsop"
+	"github.com/jroimartin/gocui"
+	"github.com/pkg/errors"
+)
+
+// Height of the test results panel, including the frame
+const testsHeight = 14
+
+// Coverage profile of the last test run and where the HTML report is
+// written, relative to the working directory
+const (
+	coverProfile = "vsop-cover.out"
+	coverReport  = "vsop-cover.html"
+)
+
+var (
+	// testMode runs the tests after each build
+	testMode = false
+	// testPkgs from --testPkgs, empty to test the packages a change affects
+	testPkgs string
+	tester   *vsop.Tester
+	// packageGraph finds the packages a change affects
+	packageGraph *vsop.PackageGraph
+	// showTests is true while the test results panel is open
+	showTests = false
+)
+
+// changedPackages logs the packages the changed files are in and the ones
+// they affect, then tests the affected packages in test mode when the build
+// worked. What a moved directory affects can't be worked out, so everything
+// is tested after one.
+func changedPackages(files []string, moved bool, built bool) {
+	changed, affected, err := packageGraph.Affected(files)
+	if err != nil {
+		logV.Err(errors.Wrap(err, "package graph"))
+	}
+	if len(changed) > 0 {
+		msg := "Changed " + strings.Join(changed, ", ")
+		if others := without(affected, changed); len(others) > 0 {
+			msg += ", affects " + strings.Join(others, ", ")
+		}
+		logV.Info(msg)
+	}
+
+	if !testMode || !built {
+		return
+	}
+	if moved {
+		testAll()
+		return
+	}
+	pkgs := strings.Fields(testPkgs)
+	if len(pkgs) == 0 {
+		pkgs = affected
+	}
+	if len(pkgs) > 0 {
+		testNow(pkgs)
+	}
+}
+
+// without is list less the entries in remove
+func without(list []string, remove []string) []string {
+	skip := make(map[string]bool)
+	for _, s := range remove {
+		skip[s] = true
+	}
+	var out []string
+	for _, s := range list {
+		if !skip[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// testAll tests --testPkgs, or every package
+func testAll() {
+	pkgs := strings.Fields(testPkgs)
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	testNow(pkgs)
+}
+
+func testNow(pkgs []string) {
+	logV.Infof("Testing %s", strings.Join(pkgs, " "))
+	renderTests()
+	run, err := tester.Test(pkgs)
+	if err != nil {
+		logV.Err(errors.Wrap(err, "go test"))
+		renderTests()
+		return
+	}
+	logTestRun(run)
+	renderTests()
+}
+
+// testCounts is a summary like "12 passed, 1 failed"
+func testCounts(run *vsop.TestRun) string {
+	counts := []string{fmt.Sprintf("%d passed", run.Passed)}
+	if run.Failed > 0 {
+		counts = append(counts, fmt.Sprintf("%d failed", run.Failed))
+	}
+	if run.Skipped > 0 {
+		counts = append(counts, fmt.Sprintf("%d skipped", run.Skipped))
+	}
+	return strings.Join(counts, ", ")
+}
+
+// logTestRun logs the totals, and each failure with its output
+func logTestRun(run *vsop.TestRun) {
+	took := run.Elapsed.Round(time.Millisecond)
+	if run.Ok() {
+		logV.Infof("Tests passed: %s in %s", testCounts(run), took)
+	} else {
+		logV.Error(fmt.Sprintf("Tests FAILED: %s in %s", testCounts(run), took))
+		if run.Output != "" {
+			logV.Error(run.Output)
+		}
+		for _, r := range run.FailedTests() {
+			msg := "FAIL " + r.Package
+			if r.Test != "" {
+				msg += " " + r.Test
+			}
+			if out := strings.TrimRight(r.Output, "\n"); out != "" {
+				msg += "\n" + out
+			}
+			logV.Error(msg)
+		}
+	}
+
+	for _, pkg := range run.Dropped() {
+		logV.Warn(fmt.Sprintf("Coverage dropped: %s %.1f%% -> %.1f%%", pkg, run.PrevCoverage[pkg], run.Coverage[pkg]))
+	}
+
+	if notifications {
+		title, urgency := "Tests passed", notificator.UR_NORMAL
+		if !run.Ok() {
+			title, urgency = "Tests FAILED!", notificator.UR_CRITICAL
+		}
+		go func() {
+			if err := notifier.Push(title, testCounts(run), "", urgency); err != nil {
+				logV.Err(errors.Wrap(err, "Notification send failed"))
+			}
+		}()
+	}
+}
+
+// writeCoverReport writes the HTML coverage report of the last test run
+func writeCoverReport() error {
+	if err := tester.CoverHTML(coverReport); err != nil {
+		return errors.Wrap(err, "coverage report")
+	}
+	logV.Info("Coverage report written to " + coverReport)
+	return nil
+}
+
+// coverage is a package's coverage for the results panel, red with the
+// previous value when it dropped
+func coverage(run *vsop.TestRun, pkg string) string {
+	c, ok := run.Coverage[pkg]
+	if !ok {
+		return ""
+	}
+	if prev, ok := run.PrevCoverage[pkg]; ok && c < prev {
+		return fmt.Sprintf(" \x1b[0;31m%.1f%% (was %.1f%%)\x1b[0m", c, prev)
+	}
+	return fmt.Sprintf(" %.1f%%", c)
+}
+
+// layoutTests adds or removes the test status and results panel, returns the
+// bottom of the space left for the logs
+func layoutTests(g *gocui.Gui, maxX int, maxY int) (int, error) {
+	if testMode || tester.Last() != nil {
+		if v, err := g.SetView("tests", 21, 0, 27, 2); err != nil {
+			if err != gocui.ErrUnknownView {
+				return maxY, err
+			}
+			v.Title = "Tests"
+		}
+	}
+
+	if !showTests {
+		if err := g.DeleteView("testResults"); err != nil && err != gocui.ErrUnknownView {
+			return maxY, err
+		}
+		return maxY, nil
+	}
+
+	top := maxY - testsHeight
+	if v, err := g.SetView("testResults", -1, top, maxX, maxY); err != nil {
+		if err != gocui.ErrUnknownView {
+			return maxY, err
+		}
+		v.Wrap = true
+		v.Title = " Test Results "
+		renderTests()
+	}
+	return top - 1, nil
+}
+
+// toggleTests opens or closes the test results panel
+func toggleTests() {
+	showTests = !showTests
+	renderTests()
+}
+
+// updateTestStatus shows red or green for the last run, must be called from
+// inside g.Update
+func updateTestStatus(g *gocui.Gui) {
+	v, err := g.View("tests")
+	if err != nil {
+		return
+	}
+	v.Clear()
+	run := tester.Last()
+	switch {
+	case tester.Running():
+		v.BgColor = gocui.ColorYellow
+		fmt.Fprint(v, "....")
+	case run == nil:
+		v.BgColor = gocui.ColorBlack
+		fmt.Fprint(v, "-")
+	case run.Ok():
+		v.BgColor = gocui.ColorGreen
+		fmt.Fprint(v, "PASS")
+	default:
+		v.BgColor = gocui.ColorRed
+		fmt.Fprint(v, "FAIL")
+	}
+}
+
+// renderTests fills in the test results panel, failures first
+func renderTests() {
+	if headless {
+		return
+	}
+	updateGui(func(g *gocui.Gui) error {
+		updateTestStatus(g)
+		v, err := g.View("testResults")
+		if err != nil {
+			return nil
+		}
+		v.Clear()
+
+		run := tester.Last()
+		if tester.Running() {
+			fmt.Fprintln(v, "Running...")
+		}
+		if run == nil {
+			fmt.Fprintln(v, "No test runs yet, ctrl+g runs the tests")
+			return nil
+		}
+
+		status := colorGreen + " PASS " + colorReset
+		if !run.Ok() {
+			status = colorRed + " FAIL " + colorReset
+		}
+		fmt.Fprintf(v, "%s %s in %s (%s)\n", status, testCounts(run), run.Elapsed.Round(time.Millisecond), strings.Join(run.Packages, " "))
+		if run.Output != "" {
+			fmt.Fprintln(v, run.Output)
+		}
+
+		for _, r := range run.FailedTests() {
+			fmt.Fprintf(v, "\n%sFAIL%s %s %s (%s)\n", "\x1b[0;31m", colorReset, r.Package, r.Test, r.Elapsed)
+			fmt.Fprint(v, r.Output)
+		}
+
+		fmt.Fprintln(v)
+		for _, r := range run.PackageResults() {
+			switch r.Action {
+			case vsop.TestPass:
+				fmt.Fprintf(v, "\x1b[0;32mok\x1b[0m   %s %s%s\n", r.Package, r.Elapsed, coverage(run, r.Package))
+			case vsop.TestFail:
+				fmt.Fprintf(v, "\x1b[0;31mFAIL\x1b[0m %s %s%s\n", r.Package, r.Elapsed, coverage(run, r.Package))
+			default:
+				fmt.Fprintf(v, "\x1b[0;90m?    %s [no test files]\x1b[0m\n", r.Package)
+			}
+		}
+		return nil
+	})
+}
@@ -1,22 +1,87 @@
 package vsop
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// DepTool is the dependency manager used by the project being built
+type DepTool int
+
+const (
+	DepNone DepTool = iota
+	DepModules
+	DepDep
+)
+
+func (d DepTool) String() string {
+	switch d {
+	case DepModules:
+		return "go modules"
+	case DepDep:
+		return "dep"
+	}
+	return "none"
+}
+
+// ModuleError is a module resolution failure reported by the go command
+type ModuleError struct {
+	Module  string
+	Version string
+	Package string
+	Message string
+	Fix     string
+}
+
+func (e ModuleError) Error() string {
+	target := e.Module
+	if e.Version != "" {
+		target += "@" + e.Version
+	}
+	if target == "" {
+		target = e.Package
+	}
+	if target == "" {
+		return e.Message
+	}
+	return target + ": " + e.Message
+}
+
 type Builder struct {
-	dir       string
-	binary    string
-	errors    string
-	useGodep  bool
-	wd        string
-	buildArgs []string
+	dir          string
+	binary       string
+	errors       string
+	moduleErrors []ModuleError
+	diagnostics  []Diagnostic
+	builds       int
+	events       *EventHub
+	errorFile    string
+	useGodep     bool
+	wd           string
+	buildArgs    []string
+	depTool      DepTool
+	modRoot      string
+	// modDir holds the go.mod the build directory is in, empty when it isn't
+	// in a module, like the root of a workspace
+	modDir    string
+	workspace bool
+
+	mu sync.Mutex
+	// buildMu lets one build or dependency command run at a time
+	buildMu sync.Mutex
+	// done is closed when the running build finishes, nil when idle
+	done chan struct{}
 }
 
 func NewBuilder(dir string, bin string, useGodep bool, wd string, buildArgs []string) *Builder {
@@ -31,7 +96,70 @@ func NewBuilder(dir string, bin string, useGodep bool, wd string, buildArgs []st
 		}
 	}
 
-	return &Builder{dir: dir, binary: bin, useGodep: useGodep, wd: wd, buildArgs: buildArgs}
+	b := &Builder{dir: dir, binary: bin, useGodep: useGodep, wd: wd, buildArgs: buildArgs}
+	b.detectDepTool()
+
+	return b
+}
+
+// detectDepTool walks up from the build directory looking for go.mod, then
+// carries on to find a go.work above it. A Gopkg.toml is only used when there
+// isn't a go.mod below it. GOWORK=off turns workspaces off, as it does for go.
+func (b *Builder) detectDepTool() {
+	useWork := os.Getenv("GOWORK") != "off"
+	for dir := b.absDir(); ; dir = filepath.Dir(dir) {
+		if useWork && fileExists(filepath.Join(dir, "go.work")) {
+			b.depTool, b.modRoot, b.workspace = DepModules, dir, true
+			return
+		}
+		if b.modDir == "" && fileExists(filepath.Join(dir, "go.mod")) {
+			b.depTool, b.modRoot, b.modDir = DepModules, dir, dir
+		}
+		if b.modDir == "" && fileExists(filepath.Join(dir, "Gopkg.toml")) {
+			b.depTool = DepDep
+			return
+		}
+		if filepath.Dir(dir) == dir {
+			return
+		}
+	}
+}
+
+// absDir is the build directory as an absolute path
+func (b *Builder) absDir() string {
+	if filepath.IsAbs(b.dir) {
+		return b.dir
+	}
+	return filepath.Join(b.wd, b.dir)
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// SetEvents to publish build start and finish on
+func (b *Builder) SetEvents(events *EventHub) {
+	b.events = events
+}
+
+// SetErrorFile to write build errors to in quickfix (file:line:col: msg)
+// format after every build, the file is emptied when a build succeeds
+func (b *Builder) SetErrorFile(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errorFile = path
+	if path == "" {
+		return nil
+	}
+	return errors.Wrap(ioutil.WriteFile(path, nil, 0644), "error file")
+}
+
+// ErrorFile build errors are written to, empty when there isn't one
+func (b *Builder) ErrorFile() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.errorFile
 }
 
 func (b *Builder) Binary() string {
@@ -39,11 +167,140 @@ func (b *Builder) Binary() string {
 }
 
 func (b *Builder) Errors() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	return b.errors
 }
 
-func (b *Builder) Build() error {
-	args := append([]string{"go", "build", "-o", filepath.Join(b.wd, b.binary)}, b.buildArgs...)
+// ModuleErrors from the last build or module command
+func (b *Builder) ModuleErrors() []ModuleError {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.moduleErrors
+}
+
+// Builds is how many times Build has been called
+func (b *Builder) Builds() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.builds
+}
+
+// Diagnostics are the file:line:col messages from the last build, less the
+// ones ModuleErrors reports. The error file keeps those for their positions.
+func (b *Builder) Diagnostics() []Diagnostic {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return withoutModuleErrors(b.diagnostics, b.moduleErrors)
+}
+
+// withoutModuleErrors drops the diagnostics a module error already reports,
+// like a missing package that is also a file:line error
+func withoutModuleErrors(diags []Diagnostic, mods []ModuleError) []Diagnostic {
+	var kept []Diagnostic
+	for _, d := range diags {
+		covered := false
+		for _, m := range mods {
+			if m.Package != "" && strings.Contains(d.Message, m.Message) && strings.Contains(d.Message, m.Package) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// Building is true while Build is running
+func (b *Builder) Building() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.done != nil
+}
+
+// Wait blocks until the running build finishes, false if it gave up after
+// timeout. Returns straight away when nothing is building.
+func (b *Builder) Wait(timeout time.Duration) bool {
+	b.mu.Lock()
+	done := b.done
+	b.mu.Unlock()
+	if done == nil {
+		return true
+	}
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// DepTool detected for the build directory
+func (b *Builder) DepTool() DepTool {
+	return b.depTool
+}
+
+// ModRoot is the directory holding go.work in a workspace, or go.mod, empty
+// when not using modules
+func (b *Builder) ModRoot() string {
+	return b.modRoot
+}
+
+// Workspace is true when the build is part of a go.work workspace
+func (b *Builder) Workspace() bool {
+	return b.workspace
+}
+
+// SetBuildArgs changes the go build arguments from the next build on
+func (b *Builder) SetBuildArgs(args []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buildArgs = args
+}
+
+// begin starts a build, Building is true until end
+func (b *Builder) begin() {
+	b.buildMu.Lock()
+	b.mu.Lock()
+	b.done = make(chan struct{})
+	b.mu.Unlock()
+}
+
+func (b *Builder) end() {
+	b.mu.Lock()
+	done := b.done
+	b.done = nil
+	b.mu.Unlock()
+	if done != nil {
+		close(done)
+	}
+	b.buildMu.Unlock()
+}
+
+// Build runs go build. Building is true from the start, so requests are held
+// while prepare runs first, for killing the app and running hooks. An error
+// from prepare stops the build and is returned.
+func (b *Builder) Build(prepare func() error) error {
+	b.begin()
+	defer b.end()
+
+	if prepare != nil {
+		if err := prepare(); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	build := b.builds + 1
+	buildArgs := b.buildArgs
+	b.mu.Unlock()
+
+	b.events.Publish(EventBuildStart, build)
+
+	args := append([]string{"go", "build", "-o", filepath.Join(b.wd, b.binary)}, buildArgs...)
 
 	var command *exec.Cmd
 	if b.useGodep {
@@ -55,21 +312,129 @@ func (b *Builder) Build() error {
 
 	output, err := command.CombinedOutput()
 
-	if command.ProcessState.Success() {
+	b.mu.Lock()
+	buildErrors := b.setResult(command, output, b.absDir())
+	b.builds = build
+	b.writeErrorFile()
+	b.mu.Unlock()
+
+	if len(buildErrors) > 0 {
+		b.events.Publish(EventBuildFailed, build)
+		return errors.New(buildErrors)
+	}
+	if err != nil {
+		b.events.Publish(EventBuildFailed, build)
+		return err
+	}
+
+	b.events.Publish(EventBuildSuccess, build)
+	return nil
+}
+
+// writeErrorFile is best effort, SetErrorFile has already checked the file
+// can be written. Must be called with mu held.
+func (b *Builder) writeErrorFile() {
+	if b.errorFile == "" {
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, d := range b.diagnostics {
+		col := d.Column
+		if col < 1 {
+			col = 1
+		}
+		msg := strings.Replace(d.Message, "\n", "\n\t", -1)
+		fmt.Fprintf(&buf, "%s:%d:%d: %s\n", d.Path, d.Line, col, msg)
+	}
+	if len(b.diagnostics) == 0 {
+		// Module errors with a position are already diagnostics
+		goMod := filepath.Join(b.modRoot, "go.work")
+		if b.modDir != "" {
+			goMod = filepath.Join(b.modDir, "go.mod")
+		}
+		for _, m := range b.moduleErrors {
+			fmt.Fprintf(&buf, "%s:1:1: %s\n", goMod, m.Error())
+		}
+	}
+	if buf.Len() == 0 && b.errors != "" {
+		// Nothing parsed, the raw output is better than an empty file
+		buf.WriteString(b.errors)
+	}
+
+	ioutil.WriteFile(b.errorFile, buf.Bytes(), 0644)
+}
+
+// setResult stores the output of a failed command, parsing it into module
+// errors and diagnostics. Must be called with mu held.
+func (b *Builder) setResult(command *exec.Cmd, output []byte, dir string) string {
+	if command.ProcessState != nil && command.ProcessState.Success() {
 		b.errors = ""
 	} else {
 		b.errors = string(output)
 	}
+	b.moduleErrors = parseModuleErrors(b.errors)
+	b.diagnostics = ParseDiagnostics(b.errors, dir)
+	return b.errors
+}
 
-	if len(b.errors) > 0 {
-		return fmt.Errorf(b.errors)
+// Deps fetches dependencies with whatever tool the project uses
+func (b *Builder) Deps() error {
+	switch b.depTool {
+	case DepModules:
+		return b.ModDownload()
+	case DepDep:
+		return b.DepEnsure()
 	}
+	return errors.New("builder deps: no go.mod, go.work or Gopkg.toml found")
+}
 
-	return err
+// ModDownload runs go mod download in the module root
+func (b *Builder) ModDownload() error {
+	return b.modCommand("download")
+}
+
+// ModTidy runs go mod tidy on the module holding the build directory, tidy
+// works on a single module, not a workspace
+func (b *Builder) ModTidy() error {
+	if b.depTool == DepModules && b.modDir == "" {
+		return errors.New("builder go mod tidy: the build directory isn't in a module")
+	}
+	return b.modCommandIn(b.modDir, "tidy")
+}
+
+func (b *Builder) modCommand(sub string) error {
+	return b.modCommandIn(b.modRoot, sub)
+}
+
+func (b *Builder) modCommandIn(dir string, sub string) error {
+	if b.depTool != DepModules {
+		return errors.Errorf("builder go mod %s: not a go modules project", sub)
+	}
+
+	// go mod rewrites go.mod and go.sum, which a build would read
+	b.buildMu.Lock()
+	defer b.buildMu.Unlock()
+
+	command := exec.Command("go", "mod", sub)
+	command.Dir = dir
+	output, err := command.CombinedOutput()
+
+	b.mu.Lock()
+	modErrors := b.setResult(command, output, dir)
+	b.mu.Unlock()
+
+	if len(modErrors) > 0 {
+		return errors.Wrap(errors.New(modErrors), "builder go mod "+sub)
+	}
+	return errors.Wrap(err, "builder go mod "+sub)
 }
 
 // DepEnsure runs dep ensure in the working directory
 func (b *Builder) DepEnsure() error {
+	b.buildMu.Lock()
+	defer b.buildMu.Unlock()
+
 	var command *exec.Cmd
 	command = exec.Command("dep", "ensure")
 	output, err := command.CombinedOutput()
@@ -77,14 +442,56 @@ func (b *Builder) DepEnsure() error {
 		return errors.Wrap(err, "builder dep ensure")
 	}
 
-	if command.ProcessState.Success() {
-		b.errors = ""
-	} else {
-		b.errors = string(output)
-	}
+	b.mu.Lock()
+	depErrors := b.setResult(command, output, b.wd)
+	b.mu.Unlock()
 
-	if len(b.errors) > 0 {
-		return fmt.Errorf(b.errors)
+	if len(depErrors) > 0 {
+		return errors.New(depErrors)
 	}
 	return err
 }
+
+var (
+	// go: example.com/mod@v1.2.3: reading ...: 404 Not Found
+	reModVersion = regexp.MustCompile(`^go: ([^\s@:]+)@([^\s:]+): (.+)$`)
+	// main.go:5:2: no required module provides package example.com/pkg; to add it:
+	reModNoProvider = regexp.MustCompile(`no required module provides package ([^\s;]+)`)
+	// main.go:5:2: missing go.sum entry for module providing package example.com/pkg (imported by x)
+	reModMissingSum = regexp.MustCompile(`(missing go\.sum entry) for module providing package ([^\s;]+)`)
+	// cannot find module providing package example.com/pkg
+	reModCannotFind = regexp.MustCompile(`cannot find module providing package ([^\s:]+)`)
+	// go: updates to go.mod needed; to update it:
+	reModGoMod = regexp.MustCompile(`^go: (updates to go\.mod needed|inconsistent vendoring|go\.mod file not found.*|errors parsing go\.mod.*)`)
+)
+
+// parseModuleErrors pulls module resolution failures out of go command output,
+// indented lines following an error are taken as the suggested fix
+func parseModuleErrors(output string) []ModuleError {
+	var mods []ModuleError
+	matched := false
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			if matched && mods[len(mods)-1].Fix == "" {
+				mods[len(mods)-1].Fix = strings.TrimSpace(line)
+			}
+			continue
+		}
+		line = strings.TrimSpace(line)
+		matched = true
+		if m := reModVersion.FindStringSubmatch(line); m != nil {
+			mods = append(mods, ModuleError{Module: m[1], Version: m[2], Message: m[3]})
+		} else if m := reModNoProvider.FindStringSubmatch(line); m != nil {
+			mods = append(mods, ModuleError{Package: m[1], Message: "no required module provides package"})
+		} else if m := reModMissingSum.FindStringSubmatch(line); m != nil {
+			mods = append(mods, ModuleError{Package: m[2], Message: m[1]})
+		} else if m := reModCannotFind.FindStringSubmatch(line); m != nil {
+			mods = append(mods, ModuleError{Package: m[1], Message: "cannot find module providing package"})
+		} else if m := reModGoMod.FindStringSubmatch(line); m != nil {
+			mods = append(mods, ModuleError{Message: m[1]})
+		} else {
+			matched = false
+		}
+	}
+	return mods
+}
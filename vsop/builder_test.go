@@ -0,0 +1,239 @@
+package vsop
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDetectDepTool(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     []string
+		build     string
+		depTool   DepTool
+		modRoot   string
+		modDir    string
+		workspace bool
+	}{
+		{
+			name:    "module",
+			files:   []string{"go.mod"},
+			build:   "cmd/app",
+			depTool: DepModules,
+			modRoot: ".",
+			modDir:  ".",
+		},
+		{
+			name:      "module in a workspace",
+			files:     []string{"go.work", "svc/go.mod"},
+			build:     "svc",
+			depTool:   DepModules,
+			modRoot:   ".",
+			modDir:    "svc",
+			workspace: true,
+		},
+		{
+			name:      "workspace root",
+			files:     []string{"go.work", "svc/go.mod"},
+			build:     ".",
+			depTool:   DepModules,
+			modRoot:   ".",
+			workspace: true,
+		},
+		{
+			name:    "dep",
+			files:   []string{"Gopkg.toml"},
+			build:   "cmd",
+			depTool: DepDep,
+		},
+		{
+			name:    "module inside a dep project",
+			files:   []string{"Gopkg.toml", "svc/go.mod"},
+			build:   "svc",
+			depTool: DepModules,
+			modRoot: "svc",
+			modDir:  "svc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "vsop")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			for _, f := range append(tt.files, filepath.Join(tt.build, "main.go")) {
+				path := filepath.Join(root, f)
+				os.MkdirAll(filepath.Dir(path), 0755)
+				if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			abs := func(dir string) string {
+				if dir == "" {
+					return ""
+				}
+				return filepath.Join(root, dir)
+			}
+
+			b := NewBuilder(filepath.Join(root, tt.build), "", false, root, nil)
+			if b.depTool != tt.depTool || b.modRoot != abs(tt.modRoot) || b.modDir != abs(tt.modDir) || b.workspace != tt.workspace {
+				t.Errorf("got %v %q %q %v, want %v %q %q %v", b.depTool, b.modRoot, b.modDir, b.workspace,
+					tt.depTool, abs(tt.modRoot), abs(tt.modDir), tt.workspace)
+			}
+		})
+	}
+}
+
+func TestWithoutModuleErrors(t *testing.T) {
+	output := "# example.com/app\n" +
+		"main.go:5:2: no required module provides package example.com/dep; to add it:\n" +
+		"\tgo get example.com/dep\n" +
+		"main.go:9:2: undefined: x\n"
+	diags := ParseDiagnostics(output, "/src/app")
+	mods := parseModuleErrors(output)
+	if len(diags) != 2 || len(mods) != 1 {
+		t.Fatalf("got %d diagnostics and %d module errors, want 2 and 1", len(diags), len(mods))
+	}
+
+	want := []Diagnostic{diags[1]}
+	if got := withoutModuleErrors(diags, mods); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseModuleErrors(t *testing.T) {
+	tests := []struct {
+		output string
+		want   []ModuleError
+	}{
+		{"", nil},
+		{"# example.com/app\nmain.go:9:2: undefined: x\n", nil},
+		{
+			"go: example.com/mod@v1.2.3: reading https://proxy.golang.org/example.com/mod/@v/v1.2.3.mod: 404 Not Found\n",
+			[]ModuleError{{Module: "example.com/mod", Version: "v1.2.3", Message: "reading https://proxy.golang.org/example.com/mod/@v/v1.2.3.mod: 404 Not Found"}},
+		},
+		{
+			"main.go:5:2: no required module provides package example.com/dep; to add it:\n\tgo get example.com/dep\n",
+			[]ModuleError{{Package: "example.com/dep", Message: "no required module provides package", Fix: "go get example.com/dep"}},
+		},
+		{
+			"main.go:5:2: missing go.sum entry for module providing package example.com/dep (imported by example.com/app); to add:\n\tgo get example.com/app\n",
+			[]ModuleError{{Package: "example.com/dep", Message: "missing go.sum entry", Fix: "go get example.com/app"}},
+		},
+		{
+			"main.go:5:2: cannot find module providing package example.com/nope: module lookup disabled by GOPROXY=off\n",
+			[]ModuleError{{Package: "example.com/nope", Message: "cannot find module providing package"}},
+		},
+		{
+			"go: updates to go.mod needed; to update it:\n\tgo mod tidy\n",
+			[]ModuleError{{Message: "updates to go.mod needed", Fix: "go mod tidy"}},
+		},
+		{
+			"go: inconsistent vendoring in /src/app:\n\texample.com/dep@v1.0.0: is explicitly required in go.mod, but not marked as explicit in vendor/modules.txt\n",
+			[]ModuleError{{Message: "inconsistent vendoring", Fix: "example.com/dep@v1.0.0: is explicitly required in go.mod, but not marked as explicit in vendor/modules.txt"}},
+		},
+		// Only the first indented line is the fix, and indented lines after
+		// other output aren't
+		{
+			"go: updates to go.mod needed; to update it:\n\tgo mod tidy\n\tsecond line\n# example.com/app\n\tnot a fix\n",
+			[]ModuleError{{Message: "updates to go.mod needed", Fix: "go mod tidy"}},
+		},
+		{
+			"main.go:5:2: no required module provides package example.com/a; to add it:\n\tgo get example.com/a\n" +
+				"main.go:6:2: no required module provides package example.com/b; to add it:\n\tgo get example.com/b\n",
+			[]ModuleError{
+				{Package: "example.com/a", Message: "no required module provides package", Fix: "go get example.com/a"},
+				{Package: "example.com/b", Message: "no required module provides package", Fix: "go get example.com/b"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := parseModuleErrors(tt.output); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseModuleErrors(%q) = %+v, want %+v", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestWriteErrorFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		builder *Builder
+		want    string
+	}{
+		{
+			name:    "success",
+			builder: &Builder{},
+		},
+		{
+			name: "diagnostics",
+			builder: &Builder{
+				errors: "build output",
+				diagnostics: []Diagnostic{
+					{Path: "/src/app/main.go", Line: 9, Column: 2, Message: "undefined: x"},
+					// No column is written as 1 so every entry has the same form
+					{Path: "/src/app/util.go", Line: 3, Message: "cannot use y\n\thave int\n\twant string"},
+				},
+				moduleErrors: []ModuleError{{Package: "example.com/dep", Message: "no required module provides package"}},
+			},
+			want: "/src/app/main.go:9:2: undefined: x\n" +
+				"/src/app/util.go:3:1: cannot use y\n\t\thave int\n\t\twant string\n",
+		},
+		{
+			name: "module errors",
+			builder: &Builder{
+				errors:  "go: updates to go.mod needed",
+				modRoot: "/src",
+				modDir:  "/src/app",
+				moduleErrors: []ModuleError{
+					{Message: "updates to go.mod needed"},
+					{Module: "example.com/mod", Version: "v1.2.3", Message: "404 Not Found"},
+				},
+			},
+			want: "/src/app/go.mod:1:1: updates to go.mod needed\n" +
+				"/src/app/go.mod:1:1: example.com/mod@v1.2.3: 404 Not Found\n",
+		},
+		{
+			name: "module errors at a workspace root",
+			builder: &Builder{
+				errors:       "go: example.com/mod@v1.2.3: 404 Not Found",
+				modRoot:      "/src",
+				moduleErrors: []ModuleError{{Module: "example.com/mod", Version: "v1.2.3", Message: "404 Not Found"}},
+			},
+			want: "/src/go.work:1:1: example.com/mod@v1.2.3: 404 Not Found\n",
+		},
+		{
+			name:    "nothing parsed",
+			builder: &Builder{errors: "ld: something went wrong\n"},
+			want:    "ld: something went wrong\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "errors.txt")
+			// Left over from the last build
+			if err := ioutil.WriteFile(path, []byte("old\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			tt.builder.errorFile = path
+			tt.builder.writeErrorFile()
+
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,15 @@
 package vsop
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"os"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 type Config struct {
@@ -12,22 +18,137 @@ type Config struct {
 	ProxyTo  string `json:"proxy_to"`
 	KeyFile  string `json:"key_file"`
 	CertFile string `json:"cert_file"`
+	// LiveReload injects a script into HTML responses that reloads the page
+	// after each build
+	LiveReload bool `json:"live_reload"`
+	// BuildWait is the longest a request is held while a build is running
+	BuildWait time.Duration `json:"build_wait"`
+	// HealthPath is polled until the app answers, when empty the port is
+	// dialed instead
+	HealthPath   string        `json:"health_path"`
+	StartTimeout time.Duration `json:"start_timeout"`
+}
+
+// ProjectConfig is a project's config file. Flags are the command line flags
+// by name, watch rules, env and hooks can only be set in the file.
+type ProjectConfig struct {
+	Flags map[string]interface{}
+	Watch WatchConfig
+	Env   map[string]string
+	Hooks HooksConfig
+}
+
+// WatchConfig decides which file changes trigger a build
+type WatchConfig struct {
+	// Extensions of files that trigger a build, .go when empty
+	Extensions []string `json:"extensions"`
+	// Ignore changes to files matching these patterns, matched against the
+	// file name and the path relative to the watched directory
+	Ignore []string `json:"ignore"`
+}
+
+// HooksConfig are commands run around builds and runs, one command per entry
+type HooksConfig struct {
+	PreBuild  []string `json:"preBuild"`
+	PostBuild []string `json:"postBuild"`
+	PreRun    []string `json:"preRun"`
 }
 
-func LoadConfig(path string) (*Config, error) {
-	configFile, err := os.Open(path)
+// editorTempFiles are written by editors while saving and never trigger a
+// build: vim's write test file and swap files, backups, emacs lock and
+// autosave files, and GoLand's and gedit's temp files
+var editorTempFiles = []string{"4913", "*.swp", "*.swx", "*~", ".#*", "#*#", "*___jb_tmp___", "*___jb_old___", ".goutputstream-*"}
 
+// Triggers is true when a change to path, inside root, should trigger a build.
+// With all set any extension will do. Editor temp files never trigger one.
+func (w WatchConfig) Triggers(path string, root string, all bool) bool {
+	rel, err := filepath.Rel(root, path)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read configuration file %s", path)
+		rel = path
+	}
+	for _, pattern := range editorTempFiles {
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return false
+		}
+	}
+	for _, pattern := range w.Ignore {
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern, filepath.ToSlash(rel)); ok {
+			return false
+		}
+	}
+	if all {
+		return true
 	}
 
-	config := new(Config)
+	extensions := w.Extensions
+	if len(extensions) == 0 {
+		extensions = []string{".go"}
+	}
+	for _, ext := range extensions {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
 
-	decoder := json.NewDecoder(configFile)
-	err = decoder.Decode(&config)
+// LoadConfig reads a JSON config file, // comments are allowed
+func LoadConfig(path string) (*ProjectConfig, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to parse configuration file %s", path)
+		return nil, fmt.Errorf("Unable to read configuration file %s", path)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(stripComments(data), &raw); err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse configuration file %s", path)
+	}
+
+	config := &ProjectConfig{Flags: make(map[string]interface{})}
+	for key, value := range raw {
+		dec := json.NewDecoder(bytes.NewReader(value))
+		dec.DisallowUnknownFields()
+		dec.UseNumber()
+
+		switch key {
+		case "watch":
+			err = dec.Decode(&config.Watch)
+		case "env":
+			err = dec.Decode(&config.Env)
+		case "hooks":
+			err = dec.Decode(&config.Hooks)
+		default:
+			var flag interface{}
+			err = dec.Decode(&flag)
+			config.Flags[key] = flag
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: %s", path, key)
+		}
 	}
 
 	return config, nil
 }
+
+// stripComments blanks out // comments outside of strings
+func stripComments(data []byte) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+	inString := false
+	for i := 0; i < len(out); i++ {
+		switch {
+		case inString && out[i] == '\\':
+			i++
+		case out[i] == '"':
+			inString = !inString
+		case !inString && out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package vsop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripComments(t *testing.T) {
+	blank := func(s string) string { return strings.Repeat(" ", len(s)) }
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "no comments",
+			in:   `{"port": 3000}`,
+			want: `{"port": 3000}`,
+		},
+		{
+			name: "trailing comment",
+			in:   `{"port": 3000} // proxy port`,
+			want: `{"port": 3000} ` + blank("// proxy port"),
+		},
+		{
+			name: "comment lines keep their newlines",
+			in:   "// vsop\n{\n  \"port\": 3000, // proxy\n  \"appPort\": 3001\n}",
+			want: blank("// vsop") + "\n{\n  \"port\": 3000, " + blank("// proxy") + "\n  \"appPort\": 3001\n}",
+		},
+		{
+			name: "slashes in a string",
+			in:   `{"proxyTo": "http://localhost:3001//x"} // url`,
+			want: `{"proxyTo": "http://localhost:3001//x"} ` + blank("// url"),
+		},
+		{
+			name: "escaped quote in a string",
+			in:   `{"msg": "say \"// hi\""} // x`,
+			want: `{"msg": "say \"// hi\""} ` + blank("// x"),
+		},
+		{
+			name: "string ending in a backslash",
+			in:   `{"dir": "C:\\"} // windows`,
+			want: `{"dir": "C:\\"} ` + blank("// windows"),
+		},
+		{
+			name: "single slash",
+			in:   `{"ratio": "1/2"} /`,
+			want: `{"ratio": "1/2"} /`,
+		},
+	}
+	for _, tt := range tests {
+		if got := string(stripComments([]byte(tt.in))); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWatchConfigTriggers(t *testing.T) {
+	root := "/src/app"
+	tests := []struct {
+		watch WatchConfig
+		path  string
+		all   bool
+		want  bool
+	}{
+		// .go by default
+		{WatchConfig{}, "/src/app/main.go", false, true},
+		{WatchConfig{}, "/src/app/pkg/util.go", false, true},
+		{WatchConfig{}, "/src/app/index.tmpl", false, false},
+		{WatchConfig{}, "/src/app/go.mod", false, false},
+		{WatchConfig{}, "/src/app/index.tmpl", true, true},
+
+		// Extensions replace .go
+		{WatchConfig{Extensions: []string{".go", ".tmpl"}}, "/src/app/views/index.tmpl", false, true},
+		{WatchConfig{Extensions: []string{".tmpl"}}, "/src/app/main.go", false, false},
+
+		// Ignore matches the name or the path from the root
+		{WatchConfig{Ignore: []string{"*_test.go"}}, "/src/app/pkg/util_test.go", false, false},
+		{WatchConfig{Ignore: []string{"*_test.go"}}, "/src/app/pkg/util.go", false, true},
+		{WatchConfig{Ignore: []string{"gen/*.go"}}, "/src/app/gen/models.go", false, false},
+		{WatchConfig{Ignore: []string{"gen/*.go"}}, "/src/app/pkg/gen/models.go", false, true},
+		{WatchConfig{Ignore: []string{"*.log"}}, "/src/app/app.log", true, false},
+		{WatchConfig{Ignore: []string{"["}}, "/src/app/main.go", false, true},
+
+		// Editor temp files, even with all
+		{WatchConfig{}, "/src/app/4913", true, false},
+		{WatchConfig{}, "/src/app/.main.go.swp", true, false},
+		{WatchConfig{}, "/src/app/main.go~", true, false},
+		{WatchConfig{}, "/src/app/.#main.go", true, false},
+		{WatchConfig{}, "/src/app/#main.go#", true, false},
+		{WatchConfig{}, "/src/app/main.go___jb_tmp___", true, false},
+		{WatchConfig{}, "/src/app/.goutputstream-AB12CD", true, false},
+
+		// A relative path with an absolute root can't be made relative, the
+		// name is still matched
+		{WatchConfig{Ignore: []string{"*_test.go"}}, "pkg/util_test.go", false, false},
+		{WatchConfig{}, "pkg/util.go", false, true},
+	}
+	for _, tt := range tests {
+		if got := tt.watch.Triggers(tt.path, root, tt.all); got != tt.want {
+			t.Errorf("%+v.Triggers(%q, all %v) = %v, want %v", tt.watch, tt.path, tt.all, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package vsop
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Severity of a compiler diagnostic
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// MarshalText writes the severity by name in JSON
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	*s = SeverityError
+	if string(text) == "warning" {
+		*s = SeverityWarning
+	}
+	return nil
+}
+
+// Diagnostic is a single file:line:col message from the go command
+type Diagnostic struct {
+	// File as printed by the compiler, usually relative to the build directory
+	File string
+	// Path is the absolute path to File
+	Path     string
+	Line     int
+	Column   int
+	Package  string
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	pos := d.File
+	if d.Line > 0 {
+		pos += ":" + strconv.Itoa(d.Line)
+		if d.Column > 0 {
+			pos += ":" + strconv.Itoa(d.Column)
+		}
+	}
+	return fmt.Sprintf("%s: %s", pos, d.Message)
+}
+
+var (
+	// # example.com/project/pkg, go vet repeats it as # [example.com/project/pkg]
+	reDiagPackage = regexp.MustCompile(`^# \[?([^\s\]]+)`)
+	// ./main.go:10:2: undefined: foo
+	reDiagPosition = regexp.MustCompile(`^(\S+?\.(?:go|s|c|h|mod|work)):(\d+)(?::(\d+))?: (.+)$`)
+	reDiagWarning  = regexp.MustCompile(`^(?:warning|vet): `)
+)
+
+// ParseDiagnostics reads compiler output, dir is used to turn relative file
+// names into absolute paths. Tab indented lines are treated as a continuation
+// of the message above.
+func ParseDiagnostics(output string, dir string) []Diagnostic {
+	var diags []Diagnostic
+	pkg := ""
+	last := -1
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			if last >= 0 {
+				diags[last].Message += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if m := reDiagPackage.FindStringSubmatch(line); m != nil {
+			pkg = m[1]
+			last = -1
+			continue
+		}
+		// go vet puts this in front of errors from type checking
+		m := reDiagPosition.FindStringSubmatch(strings.TrimPrefix(line, "vet: "))
+		if m == nil {
+			last = -1
+			continue
+		}
+
+		d := Diagnostic{File: m[1], Package: pkg, Message: m[4]}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		if reDiagWarning.MatchString(d.Message) {
+			d.Severity = SeverityWarning
+			d.Message = reDiagWarning.ReplaceAllString(d.Message, "")
+		}
+		d.Path = d.File
+		if !filepath.IsAbs(d.Path) {
+			d.Path = filepath.Join(dir, d.Path)
+		}
+		d.Path = filepath.Clean(d.Path)
+
+		diags = append(diags, d)
+		last = len(diags) - 1
+	}
+	return diags
+}
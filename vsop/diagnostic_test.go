@@ -0,0 +1,91 @@
+package vsop
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		diags  []Diagnostic
+	}{
+		{
+			name:   "nothing to report",
+			output: "go: downloading example.com/dep v1.0.0\n",
+		},
+		{
+			name: "compiler errors",
+			output: "# example.com/app\n" +
+				"./main.go:6:2: declared and not used: x\n" +
+				"./main.go:8:9: too many return values\n" +
+				"\thave (number)\n" +
+				"\twant ()\n",
+			diags: []Diagnostic{
+				{File: "./main.go", Path: "/src/app/main.go", Line: 6, Column: 2, Package: "example.com/app", Message: "declared and not used: x"},
+				{File: "./main.go", Path: "/src/app/main.go", Line: 8, Column: 9, Package: "example.com/app", Message: "too many return values\nhave (number)\nwant ()"},
+			},
+		},
+		{
+			name: "several packages",
+			output: "# example.com/app/util\n" +
+				"util/util.go:3:1: syntax error: non-declaration statement outside function body\n" +
+				"# example.com/app/api\n" +
+				"/src/app/api/api.go:10: undefined: util.Add\n",
+			diags: []Diagnostic{
+				{File: "util/util.go", Path: "/src/app/util/util.go", Line: 3, Column: 1, Package: "example.com/app/util", Message: "syntax error: non-declaration statement outside function body"},
+				{File: "/src/app/api/api.go", Path: "/src/app/api/api.go", Line: 10, Package: "example.com/app/api", Message: "undefined: util.Add"},
+			},
+		},
+		{
+			name: "go vet",
+			output: "# example.com/app\n" +
+				"# [example.com/app]\n" +
+				"./main.go:7:14: fmt.Printf format %d has arg s of wrong type string\n" +
+				"vet: ./main.go:9:2: undefined: y\n",
+			diags: []Diagnostic{
+				{File: "./main.go", Path: "/src/app/main.go", Line: 7, Column: 14, Package: "example.com/app", Message: "fmt.Printf format %d has arg s of wrong type string"},
+				{File: "./main.go", Path: "/src/app/main.go", Line: 9, Column: 2, Package: "example.com/app", Message: "undefined: y"},
+			},
+		},
+		{
+			name:   "warning",
+			output: "./main.go:4:1: warning: unusual thing\n",
+			diags: []Diagnostic{
+				{File: "./main.go", Path: "/src/app/main.go", Line: 4, Column: 1, Severity: SeverityWarning, Message: "unusual thing"},
+			},
+		},
+		{
+			name:   "go.mod",
+			output: "go: errors parsing go.mod:\ngo.mod:3: unknown directive: requir\n",
+			diags: []Diagnostic{
+				{File: "go.mod", Path: "/src/app/go.mod", Line: 3, Message: "unknown directive: requir"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := ParseDiagnostics(tt.output, "/src/app")
+			if !reflect.DeepEqual(diags, tt.diags) {
+				t.Errorf("got %+v, want %+v", diags, tt.diags)
+			}
+		})
+	}
+}
+
+func TestDiagnosticString(t *testing.T) {
+	tests := []struct {
+		diag Diagnostic
+		want string
+	}{
+		{Diagnostic{File: "./main.go", Line: 6, Column: 2, Message: "undefined: x"}, "./main.go:6:2: undefined: x"},
+		{Diagnostic{File: "go.mod", Line: 3, Message: "unknown directive"}, "go.mod:3: unknown directive"},
+		{Diagnostic{File: "main.go", Message: "no position"}, "main.go: no position"},
+	}
+	for _, tt := range tests {
+		if got := tt.diag.String(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.diag, got, tt.want)
+		}
+	}
+}
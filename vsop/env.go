@@ -0,0 +1,124 @@
+package vsop
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+)
+
+// SetEnvFiles are read on every run of the app, later files win. Missing
+// files are skipped.
+func (r *Runner) SetEnvFiles(files []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.envFiles = files
+}
+
+// SetEnvDefaults are used for the app when not set by vsop's environment or
+// an env file
+func (r *Runner) SetEnvDefaults(env map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.envDefaults = env
+}
+
+// SetEnvVar sets a variable for the app that wins over everything else
+func (r *Runner) SetEnvVar(key string, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.envVars == nil {
+		r.envVars = make(map[string]string)
+	}
+	r.envVars[key] = value
+}
+
+// Environ is the environment the app gets when it's next run. Variables in
+// vsop's own environment aren't overridden by env files or defaults. An env
+// file that can't be read is returned as an error, the rest are still used.
+func (r *Runner) Environ() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.environ()
+}
+
+func (r *Runner) environ() ([]string, error) {
+	env := make(map[string]string)
+	for k, v := range r.envDefaults {
+		env[k] = v
+	}
+
+	var firstErr error
+	for _, file := range r.envFiles {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			continue
+		}
+		vars, err := godotenv.Read(file)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "env file %s", file)
+			}
+			continue
+		}
+		for k, v := range vars {
+			env[k] = v
+		}
+	}
+
+	environ := os.Environ()
+	for _, kv := range environ {
+		delete(env, strings.SplitN(kv, "=", 2)[0])
+	}
+	for k, v := range r.envVars {
+		env[k] = v
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		environ = append(environ, k+"="+env[k])
+	}
+	return environ, firstErr
+}
+
+// EnvChanges are the names of the variables that differ between the running
+// app and what it would get if run now
+func (r *Runner) EnvChanges() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.command == nil || r.exited() {
+		return nil, nil
+	}
+
+	next, err := r.environ()
+	was, now := envMap(r.command.Env), envMap(next)
+	var changed []string
+	for k, v := range now {
+		if old, ok := was[k]; !ok || old != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range was {
+		if _, ok := now[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed, err
+}
+
+func envMap(environ []string) map[string]string {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env
+}
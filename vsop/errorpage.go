@@ -0,0 +1,181 @@
+package vsop
+
+import (
+	"bufio"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// How many lines either side of a diagnostic to show on the error page
+const excerptContext = 3
+
+type errorPage struct {
+	Title     string
+	Status    int
+	Packages  []errorPagePackage
+	Modules   []ModuleError
+	Raw       string
+	ScriptURL string
+}
+
+type errorPagePackage struct {
+	Name        string
+	Diagnostics []errorPageDiagnostic
+}
+
+type errorPageDiagnostic struct {
+	Diagnostic
+	Excerpt []excerptLine
+}
+
+type excerptLine struct {
+	Number  int
+	Text    string
+	Current bool
+	// Column marker, only set on the current line
+	Marker string
+}
+
+// renderErrorPage writes an HTML page listing diagnostics with the source
+// around each one. The page loads the live reload script so it reloads itself
+// once a build other than build finishes.
+func renderErrorPage(res http.ResponseWriter, status int, title string, build int, diags []Diagnostic, mods []ModuleError, raw string) error {
+	page := errorPage{
+		Title:     title,
+		Status:    status,
+		Modules:   mods,
+		ScriptURL: liveReloadURL(build, true),
+	}
+
+	files := make(map[string][]string)
+	for _, d := range diags {
+		if len(page.Packages) == 0 || page.Packages[len(page.Packages)-1].Name != d.Package {
+			page.Packages = append(page.Packages, errorPagePackage{Name: d.Package})
+		}
+		pkg := &page.Packages[len(page.Packages)-1]
+		pkg.Diagnostics = append(pkg.Diagnostics, errorPageDiagnostic{
+			Diagnostic: d,
+			Excerpt:    sourceExcerpt(files, d),
+		})
+	}
+	if len(diags) == 0 && len(mods) == 0 {
+		page.Raw = raw
+	}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(status)
+	return errorPageTemplate.Execute(res, page)
+}
+
+// sourceExcerpt reads the lines around a diagnostic, files are cached so a
+// file with many errors is only read once
+func sourceExcerpt(files map[string][]string, d Diagnostic) []excerptLine {
+	if d.Line <= 0 || filepath.Ext(d.Path) != ".go" {
+		return nil
+	}
+
+	lines, ok := files[d.Path]
+	if !ok {
+		lines = readLines(d.Path)
+		files[d.Path] = lines
+	}
+	if d.Line > len(lines) {
+		return nil
+	}
+
+	start := d.Line - excerptContext
+	if start < 1 {
+		start = 1
+	}
+	end := d.Line + excerptContext
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	var excerpt []excerptLine
+	for n := start; n <= end; n++ {
+		el := excerptLine{Number: n, Text: lines[n-1], Current: n == d.Line}
+		if el.Current && d.Column > 0 {
+			// Columns count bytes, the marker needs a space per rune
+			marker := []rune{}
+			for i, r := range el.Text {
+				if i >= d.Column-1 {
+					break
+				}
+				if r == '\t' {
+					marker = append(marker, '\t')
+				} else {
+					marker = append(marker, ' ')
+				}
+			}
+			el.Marker = string(append(marker, '^'))
+		}
+		excerpt = append(excerpt, el)
+	}
+	return excerpt
+}
+
+func readLines(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+var errorPageTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Status}} {{.Title}} - VSOP</title>
+<style>
+body { font-family: -apple-system, sans-serif; margin: 0; background: #1e1e1e; color: #ddd; }
+header { background: #b52a2a; color: #fff; padding: 1em 2em; }
+header h1 { margin: 0; font-size: 1.4em; }
+header p { margin: .3em 0 0; opacity: .8; }
+main { padding: 1em 2em; }
+h2 { font-size: 1.1em; color: #9cdcfe; font-family: monospace; }
+.diag { margin: 0 0 1.5em; }
+.msg { font-family: monospace; white-space: pre-wrap; color: #f48771; }
+.file { font-family: monospace; color: #aaa; }
+.warning .msg { color: #cca700; }
+pre { background: #111; padding: .5em 0; margin: .4em 0 0; overflow-x: auto; tab-size: 4; }
+pre span { display: block; padding: 0 1em; }
+pre .current { background: #4b1818; }
+pre .marker { color: #f48771; }
+pre i { display: inline-block; width: 4em; color: #666; font-style: normal; }
+.fix { font-family: monospace; color: #6a9955; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+<p>This page will reload when the next build finishes.</p>
+</header>
+<main>
+{{range .Modules}}<div class="diag">
+<div class="msg">{{.Error}}</div>
+{{if .Fix}}<div class="fix">fix: {{.Fix}}</div>{{end}}
+</div>
+{{end}}{{range .Packages}}{{if .Name}}<h2># {{.Name}}</h2>{{end}}
+{{range .Diagnostics}}<div class="diag {{.Severity}}">
+<div class="file">{{.File}}{{if .Line}}:{{.Line}}{{if .Column}}:{{.Column}}{{end}}{{end}}</div>
+<div class="msg">{{.Message}}</div>
+{{if .Excerpt}}<pre>{{range .Excerpt}}<span{{if .Current}} class="current"{{end}}><i>{{.Number}}</i>{{.Text}}</span>{{if .Marker}}<span class="marker"><i></i>{{.Marker}}</span>{{end}}{{end}}</pre>{{end}}
+</div>
+{{end}}{{end}}{{if .Raw}}<pre><span>{{.Raw}}</span></pre>{{end}}
+</main>
+<script src="{{.ScriptURL}}"></script>
+</body>
+</html>
+`))
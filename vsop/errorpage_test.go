@@ -0,0 +1,143 @@
+package vsop
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSourceExcerpt(t *testing.T) {
+	src := []string{
+		"package main",            // 1
+		"",                        // 2
+		"func main() {",           // 3
+		"\tx := \"héllo\" + y",    // 4
+		"\tfmt.Println(x)",        // 5
+		"}",                       // 6
+		"",                        // 7
+		"func other() { z := 1 }", // 8
+	}
+	files := map[string][]string{"/src/main.go": src}
+
+	tests := []struct {
+		name string
+		d    Diagnostic
+		want []excerptLine
+	}{
+		{
+			name: "context either side",
+			d:    Diagnostic{Path: "/src/main.go", Line: 5, Column: 2},
+			want: []excerptLine{
+				{Number: 2, Text: src[1]},
+				{Number: 3, Text: src[2]},
+				{Number: 4, Text: src[3]},
+				{Number: 5, Text: src[4], Current: true, Marker: "\t^"},
+				{Number: 6, Text: src[5]},
+				{Number: 7, Text: src[6]},
+				{Number: 8, Text: src[7]},
+			},
+		},
+		{
+			name: "first line",
+			d:    Diagnostic{Path: "/src/main.go", Line: 1, Column: 1},
+			want: []excerptLine{
+				{Number: 1, Text: src[0], Current: true, Marker: "^"},
+				{Number: 2, Text: src[1]},
+				{Number: 3, Text: src[2]},
+				{Number: 4, Text: src[3]},
+			},
+		},
+		{
+			name: "last line",
+			d:    Diagnostic{Path: "/src/main.go", Line: 8, Column: 16},
+			want: []excerptLine{
+				{Number: 5, Text: src[4]},
+				{Number: 6, Text: src[5]},
+				{Number: 7, Text: src[6]},
+				{Number: 8, Text: src[7], Current: true, Marker: "               ^"},
+			},
+		},
+		{
+			// The column counts bytes, é is two of them but takes one space,
+			// and the tab is kept so the marker lines up under the y
+			name: "column after a multi-byte rune",
+			d:    Diagnostic{Path: "/src/main.go", Line: 4, Column: 18},
+			want: []excerptLine{
+				{Number: 1, Text: src[0]},
+				{Number: 2, Text: src[1]},
+				{Number: 3, Text: src[2]},
+				{Number: 4, Text: src[3], Current: true, Marker: "\t" + strings.Repeat(" ", 15) + "^"},
+				{Number: 5, Text: src[4]},
+				{Number: 6, Text: src[5]},
+				{Number: 7, Text: src[6]},
+			},
+		},
+		{
+			name: "no column",
+			d:    Diagnostic{Path: "/src/main.go", Line: 6},
+			want: []excerptLine{
+				{Number: 3, Text: src[2]},
+				{Number: 4, Text: src[3]},
+				{Number: 5, Text: src[4]},
+				{Number: 6, Text: src[5], Current: true},
+				{Number: 7, Text: src[6]},
+				{Number: 8, Text: src[7]},
+			},
+		},
+		{
+			name: "line past the end",
+			d:    Diagnostic{Path: "/src/main.go", Line: 9, Column: 1},
+		},
+		{
+			name: "no line",
+			d:    Diagnostic{Path: "/src/main.go", Column: 1},
+		},
+		{
+			name: "not a go file",
+			d:    Diagnostic{Path: "/src/go.mod", Line: 1, Column: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sourceExcerpt(files, tt.d); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceExcerptReadsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(path, []byte("package main\nvar x = y\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]string)
+	want := []excerptLine{
+		{Number: 1, Text: "package main"},
+		{Number: 2, Text: "var x = y", Current: true, Marker: "        ^"},
+	}
+	if got := sourceExcerpt(files, Diagnostic{Path: path, Line: 2, Column: 9}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	if _, ok := files[path]; !ok {
+		t.Errorf("%s wasn't cached", path)
+	}
+
+	// A missing file is cached as empty and has no excerpt
+	missing := filepath.Join(dir, "gone.go")
+	if got := sourceExcerpt(files, Diagnostic{Path: missing, Line: 1, Column: 1}); got != nil {
+		t.Errorf("got %#v for a missing file", got)
+	}
+	if _, ok := files[missing]; !ok {
+		t.Errorf("%s wasn't cached", missing)
+	}
+}
@@ -0,0 +1,71 @@
+package vsop
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published by the builder, runner and tester
+const (
+	EventBuildStart   = "build-start"
+	EventBuildSuccess = "build-success"
+	EventBuildFailed  = "build-failed"
+	EventRun          = "run"
+	EventKill         = "kill"
+	EventTestStart    = "test-start"
+	EventTestPass     = "test-pass"
+	EventTestFail     = "test-fail"
+)
+
+// Event is something that happened to the build or the app
+type Event struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Build int       `json:"build"`
+}
+
+// EventHub fans events out to subscribers, a nil hub drops everything
+type EventHub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel that receives every event published from now on
+func (h *EventHub) Subscribe() chan Event {
+	ch := make(chan Event, 16)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops and closes the channel
+func (h *EventHub) Unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+	h.mu.Unlock()
+}
+
+// Publish sends the event to all subscribers, slow subscribers miss out rather
+// than block the build
+func (h *EventHub) Publish(typ string, build int) {
+	if h == nil {
+		return
+	}
+	e := Event{Type: typ, Time: time.Now(), Build: build}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
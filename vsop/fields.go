@@ -0,0 +1,178 @@
+package vsop
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Field keys that hold the message of a structured log line
+var messageKeys = []string{"msg", "message"}
+
+// Field keys already shown in the log view columns, left out of the summary
+var summarySkipKeys = map[string]bool{
+	"level": true, "lvl": true, "severity": true,
+	"time": true, "ts": true, "timestamp": true,
+}
+
+// Field keys shown first in the summary
+var summaryKeys = []string{"request_id", "user_id", "error", "err"}
+
+// How many fields make it into the summary
+const summaryFields = 4
+
+// ParseFields decodes a JSON object or logfmt line into fields, ok is false
+// when the line is neither
+func ParseFields(msg string) (map[string]string, bool) {
+	trimmed := strings.TrimSpace(msg)
+	if strings.HasPrefix(trimmed, "{") {
+		return parseJSONFields(trimmed)
+	}
+	return parseLogfmtFields(trimmed)
+}
+
+func parseJSONFields(msg string) (map[string]string, bool) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(msg), &raw); err != nil {
+		return nil, false
+	}
+
+	fields := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			fields[k] = v
+		case nil:
+			fields[k] = "null"
+		case float64, bool:
+			fields[k] = fmt.Sprint(v)
+		default:
+			data, _ := json.Marshal(v)
+			fields[k] = string(data)
+		}
+	}
+	return fields, true
+}
+
+// parseLogfmtFields only accepts lines that are entirely key=value pairs, with
+// at least two of them, so ordinary sentences with an = are left alone
+func parseLogfmtFields(msg string) (map[string]string, bool) {
+	fields := make(map[string]string)
+	for len(msg) > 0 {
+		eq := strings.IndexByte(msg, '=')
+		if eq <= 0 || strings.ContainsAny(msg[:eq], " \t\"") {
+			return nil, false
+		}
+		key := msg[:eq]
+		msg = msg[eq+1:]
+
+		value := ""
+		if strings.HasPrefix(msg, `"`) {
+			end := 1
+			for end < len(msg) && msg[end] != '"' {
+				if msg[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(msg) {
+				return nil, false
+			}
+			value = strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\n`, "\n", `\t`, "\t").Replace(msg[1:end])
+			msg = msg[end+1:]
+		} else if sp := strings.IndexAny(msg, " \t"); sp >= 0 {
+			value = msg[:sp]
+			msg = msg[sp:]
+		} else {
+			value = msg
+			msg = ""
+		}
+
+		fields[key] = value
+		msg = strings.TrimLeft(msg, " \t")
+	}
+
+	if len(fields) < 2 {
+		return nil, false
+	}
+	return fields, true
+}
+
+// Summary is a one line version of the entry, for structured lines the
+// message followed by a few of the fields, for traces the first line
+func (l LogLineLog) Summary() string {
+	if l.Stack {
+		lines := strings.Split(l.Message, "\n")
+		return fmt.Sprintf("%s (+%d lines)", lines[0], len(lines)-1)
+	}
+	if len(l.Fields) == 0 {
+		return l.Message
+	}
+
+	msg := ""
+	used := make(map[string]bool)
+	for _, k := range messageKeys {
+		if v, ok := l.Fields[k]; ok {
+			msg = v
+			used[k] = true
+			break
+		}
+	}
+
+	keys := make([]string, 0, len(l.Fields))
+	for _, k := range summaryKeys {
+		if _, ok := l.Fields[k]; ok {
+			keys = append(keys, k)
+			used[k] = true
+		}
+	}
+	rest := make([]string, 0, len(l.Fields))
+	for k := range l.Fields {
+		if !used[k] && !summarySkipKeys[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	keys = append(keys, rest...)
+
+	parts := []string{msg}
+	shown := 0
+	for _, k := range keys {
+		v := l.Fields[k]
+		if shown == summaryFields || strings.ContainsAny(v, "\n") || len(v) > 60 {
+			continue
+		}
+		parts = append(parts, k+"="+v)
+		shown++
+	}
+	if hidden := len(keys) - shown; hidden > 0 {
+		parts = append(parts, fmt.Sprintf("(+%d)", hidden))
+	}
+	return strings.TrimSpace(strings.Join(parts, " "))
+}
+
+// FieldKeys are the field names sorted, message first
+func (l LogLineLog) FieldKeys() []string {
+	keys := make([]string, 0, len(l.Fields))
+	for k := range l.Fields {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		mi, mj := isMessageKey(keys[i]), isMessageKey(keys[j])
+		if mi != mj {
+			return mi
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func isMessageKey(k string) bool {
+	for _, m := range messageKeys {
+		if k == m {
+			return true
+		}
+	}
+	return false
+}
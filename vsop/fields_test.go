@@ -0,0 +1,117 @@
+package vsop
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    string
+		fields map[string]string
+		ok     bool
+	}{
+		{
+			name: "json",
+			msg:  `{"level":"info","msg":"started","port":3000,"tls":false,"user":null,"tags":["a","b"],"req":{"id":"x"}}`,
+			fields: map[string]string{
+				"level": "info", "msg": "started", "port": "3000", "tls": "false",
+				"user": "null", "tags": `["a","b"]`, "req": `{"id":"x"}`,
+			},
+			ok: true,
+		},
+		{
+			name: "json with surrounding space",
+			msg:  "  {\"msg\":\"hi\"}\n",
+			fields: map[string]string{
+				"msg": "hi",
+			},
+			ok: true,
+		},
+		{
+			name: "broken json",
+			msg:  `{"msg":"cut off`,
+		},
+		{
+			name: "logfmt",
+			msg:  `time=2024-01-02T15:04:05Z level=info msg="request done" path=/api status=200`,
+			fields: map[string]string{
+				"time": "2024-01-02T15:04:05Z", "level": "info", "msg": "request done",
+				"path": "/api", "status": "200",
+			},
+			ok: true,
+		},
+		{
+			name: "logfmt escapes and empty values",
+			msg:  `msg="say \"hi\"\nbye" err= dir="C:\\tmp"`,
+			fields: map[string]string{
+				"msg": "say \"hi\"\nbye", "err": "", "dir": `C:\tmp`,
+			},
+			ok: true,
+		},
+		{
+			name: "one pair isn't enough",
+			msg:  "retry=3",
+		},
+		{
+			name: "sentence with an equals sign",
+			msg:  "set x=1 before starting",
+		},
+		{
+			name: "unterminated quote",
+			msg:  `msg="open level=info`,
+		},
+		{
+			name: "plain line",
+			msg:  "listening on :3000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, ok := ParseFields(tt.msg)
+			if ok != tt.ok || !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("got %v, %v, want %v, %v", fields, ok, tt.fields, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		name string
+		line LogLineLog
+		want string
+	}{
+		{
+			name: "plain",
+			line: LogLineLog{Message: "listening on :3000"},
+			want: "listening on :3000",
+		},
+		{
+			name: "fields",
+			line: LogLineLog{Fields: map[string]string{
+				"msg": "request done", "level": "info", "time": "now",
+				"path": "/api", "status": "200", "request_id": "r1",
+			}},
+			want: "request done request_id=r1 path=/api status=200",
+		},
+		{
+			name: "too many fields",
+			line: LogLineLog{Fields: map[string]string{
+				"message": "busy", "a": "1", "b": "2", "c": "3", "d": "4", "e": "5",
+			}},
+			want: "busy a=1 b=2 c=3 d=4 (+1)",
+		},
+		{
+			name: "stack",
+			line: LogLineLog{Message: "panic: boom\n\ngoroutine 1 [running]:", Stack: true},
+			want: "panic: boom (+2 lines)",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.line.Summary(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package vsop
+
+import (
+	"os/exec"
+	"strings"
+
+	shellwords "github.com/mattn/go-shellwords"
+	"github.com/pkg/errors"
+)
+
+// RunHooks runs each command in dir with env, logging its output, and stops
+// at the first one that fails. A nil env is vsop's own environment.
+func RunHooks(name string, commands []string, dir string, env []string, log LineLogNamespace) error {
+	for _, command := range commands {
+		args, err := shellwords.Parse(command)
+		if err != nil {
+			return errors.Wrapf(err, "%s hook %q", name, command)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		log.Infof("%s hook: %s", name, command)
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = dir
+		cmd.Env = env
+		output, err := cmd.CombinedOutput()
+		for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+			if line != "" {
+				log.Info(line)
+			}
+		}
+		if err != nil {
+			return errors.Wrapf(err, "%s hook %q", name, command)
+		}
+	}
+	return nil
+}
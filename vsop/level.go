@@ -0,0 +1,123 @@
+package vsop
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// LevelDetector guesses the level of a line of app output, ok is false when
+// it can't tell
+type LevelDetector func(msg string) (level LogLineLevel, ok bool)
+
+// LevelDetectors are tried in order by DetectLevel, append to add formats
+var LevelDetectors = []LevelDetector{
+	DetectPanicLevel,
+	DetectJSONLevel,
+	DetectLogfmtLevel,
+	DetectPrefixLevel,
+}
+
+// DetectLevel runs the LevelDetectors over msg, fallback is used when none of
+// them recognise it
+func DetectLevel(msg string, fallback LogLineLevel) LogLineLevel {
+	for _, detect := range LevelDetectors {
+		if level, ok := detect(msg); ok {
+			return level
+		}
+	}
+	return fallback
+}
+
+// ParseLevel maps common level names to a LogLineLevel, case insensitive. slog
+// style offsets like "ERROR+2" are ignored.
+func ParseLevel(name string) (LogLineLevel, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if i := strings.IndexAny(name, "+-"); i > 0 {
+		name = name[:i]
+	}
+	switch name {
+	case "trace", "debug", "debu", "dbg":
+		return LogDebug, true
+	case "info", "information", "notice":
+		return LogInfo, true
+	case "warn", "warning", "wrn":
+		return LogWarn, true
+	case "error", "erro", "err", "eror":
+		return LogError, true
+	case "fatal", "fata", "crit", "critical", "alert", "emerg", "emergency":
+		return LogFatal, true
+	case "panic", "pani", "dpanic":
+		return LogPanic, true
+	}
+	return LogInfo, false
+}
+
+// DetectPanicLevel spots the start of a Go panic or runtime fatal error
+func DetectPanicLevel(msg string) (LogLineLevel, bool) {
+	if strings.HasPrefix(msg, "panic: ") {
+		return LogPanic, true
+	}
+	if strings.HasPrefix(msg, "fatal error: ") {
+		return LogFatal, true
+	}
+	return LogInfo, false
+}
+
+// Keys used for the level by logrus, zap, slog and friends
+var jsonLevelKeys = []string{"level", "lvl", "severity", "log.level", "loglevel"}
+
+// DetectJSONLevel reads the level field of a JSON log line
+func DetectJSONLevel(msg string) (LogLineLevel, bool) {
+	msg = strings.TrimSpace(msg)
+	if !strings.HasPrefix(msg, "{") {
+		return LogInfo, false
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(msg), &fields); err != nil {
+		return LogInfo, false
+	}
+	for _, key := range jsonLevelKeys {
+		if name, ok := fields[key].(string); ok {
+			return ParseLevel(name)
+		}
+	}
+	return LogInfo, false
+}
+
+var reLogfmtLevel = regexp.MustCompile(`(?:^|\s)(?:level|lvl|severity)="?([A-Za-z]+)`)
+
+// DetectLogfmtLevel reads level=error style fields
+func DetectLogfmtLevel(msg string) (LogLineLevel, bool) {
+	m := reLogfmtLevel.FindStringSubmatch(msg)
+	if m == nil {
+		return LogInfo, false
+	}
+	return ParseLevel(m[1])
+}
+
+var (
+	// [ERROR] something, possibly after a timestamp
+	reBracketLevel = regexp.MustCompile(`^[^\[]{0,40}\[([A-Za-z]+)\]`)
+	// ERRO[0000] something, logrus text format
+	reLogrusLevel = regexp.MustCompile(`^([A-Z]{4})\[\d+\]`)
+	// ERROR: something
+	rePrefixLevel = regexp.MustCompile(`^([A-Za-z]+):? `)
+)
+
+// DetectPrefixLevel reads [ERROR], ERRO[0000] and ERROR: style prefixes
+func DetectPrefixLevel(msg string) (LogLineLevel, bool) {
+	if m := reLogrusLevel.FindStringSubmatch(msg); m != nil {
+		return ParseLevel(m[1])
+	}
+	if m := reBracketLevel.FindStringSubmatch(msg); m != nil {
+		if level, ok := ParseLevel(m[1]); ok {
+			return level, true
+		}
+	}
+	if m := rePrefixLevel.FindStringSubmatch(msg); m != nil && strings.ToUpper(m[1]) == m[1] {
+		return ParseLevel(m[1])
+	}
+	return LogInfo, false
+}
@@ -0,0 +1,66 @@
+package vsop
+
+import "testing"
+
+func TestDetectLevel(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want LogLineLevel
+	}{
+		// Nothing to go on, the fallback is used
+		{"listening on :3000", LogWarn},
+		{"Error: only upper case prefixes count", LogWarn},
+		{"[GIN] 2024/01/02 - 15:04:05 | 200 | GET /", LogWarn},
+		{`{"msg":"no level"}`, LogWarn},
+
+		{"panic: boom", LogPanic},
+		{"fatal error: all goroutines are asleep - deadlock!", LogFatal},
+
+		{`{"level":"info","msg":"started"}`, LogInfo},
+		{`{"level":"error","msg":"db down"}`, LogError},
+		{`{"severity":"WARNING","message":"slow"}`, LogWarn},
+		{`{"time":"2024-01-02T15:04:05Z","level":"ERROR+2","msg":"worse"}`, LogError},
+		{`{"lvl":"dbg"}`, LogDebug},
+
+		{`time=2024-01-02T15:04:05Z level=INFO msg="started"`, LogInfo},
+		{`ts=1 lvl=debug msg=tick`, LogDebug},
+		{`level="fatal" msg="gave up"`, LogFatal},
+
+		{"[ERROR] db down", LogError},
+		{"2024/01/02 15:04:05 [warn] disk filling up", LogWarn},
+		{"ERRO[0000] failed to connect", LogError},
+		{"INFO[0002] started", LogInfo},
+		{"WARN: low memory", LogWarn},
+		{"DEBUG cache miss", LogDebug},
+	}
+	for _, tt := range tests {
+		if got := DetectLevel(tt.msg, LogWarn); got != tt.want {
+			t.Errorf("DetectLevel(%q) = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level LogLineLevel
+		ok    bool
+	}{
+		{"trace", LogDebug, true},
+		{"DEBU", LogDebug, true},
+		{" Info ", LogInfo, true},
+		{"notice", LogInfo, true},
+		{"WRN", LogWarn, true},
+		{"ERROR-4", LogError, true},
+		{"crit", LogFatal, true},
+		{"dpanic", LogPanic, true},
+		{"verbose", LogInfo, false},
+		{"", LogInfo, false},
+	}
+	for _, tt := range tests {
+		level, ok := ParseLevel(tt.name)
+		if level != tt.level || ok != tt.ok {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v, %v", tt.name, level, ok, tt.level, tt.ok)
+		}
+	}
+}
@@ -8,19 +8,37 @@ import (
 )
 
 type LineLog struct {
-	Config          LogLineConfig
-	ConfigNS        map[string]*LogLineConfig
+	Config   LogLineConfig
+	ConfigNS map[string]*LogLineConfig
+	// Cap is how many lines are kept in memory, 0 for no limit. Change it
+	// with SetCap.
 	Cap             int
-	Logs            []LogLineLog
 	NamespaceFilter string
 	RegexFilter     string
+
+	mu sync.RWMutex
+	// logs is a ring buffer, start is the index of the oldest line
+	logs   []LogLineLog
+	start  int
+	nextID uint64
+	spill  *logSpill
+	sinks  []LogSink
+	subs   map[chan LogLineLog]struct{}
 }
 
 type LogLineLog struct {
+	// ID is unique within a session, set by Add
+	ID        uint64
 	Message   string
 	Namespace string
 	Level     LogLineLevel
 	Timestamp time.Time
+	// Fields parsed from a structured (JSON or logfmt) line
+	Fields map[string]string `json:",omitempty"`
+	// Stack is true when Message holds all the lines of a panic or trace
+	Stack bool `json:",omitempty"`
+	// Diagnostic is set on build error lines
+	Diagnostic *Diagnostic `json:",omitempty"`
 }
 
 type LogLineConfig struct {
@@ -39,6 +57,38 @@ const (
 	LogPanic
 )
 
+func (l LogLineLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	case LogFatal:
+		return "fatal"
+	case LogPanic:
+		return "panic"
+	}
+	return "unknown"
+}
+
+// MarshalText writes the level by name in JSON
+func (l LogLineLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *LogLineLevel) UnmarshalText(text []byte) error {
+	level, ok := ParseLevel(string(text))
+	if !ok {
+		return fmt.Errorf("unknown log level %q", text)
+	}
+	*l = level
+	return nil
+}
+
 var ll *LineLog
 var llOnce sync.Once
 
@@ -48,54 +98,172 @@ func LL() *LineLog {
 		tru := true
 		level := LogDebug
 		ll = &LineLog{
-			Cap: 1000, // TODO: implement? maybe with a really big default
+			Cap: 1000,
 			Config: LogLineConfig{
 				LevelFilter: &level,
 				Timestamp:   &tru,
 			},
 			ConfigNS: make(map[string]*LogLineConfig),
+			subs:     make(map[chan LogLineLog]struct{}),
 		}
 	})
 	return ll
 }
 
 func NewLineLogNamespace(namespace string, config *LogLineConfig) LineLogNamespace {
-
+	LL().mu.Lock()
 	LL().ConfigNS[namespace] = config
+	LL().mu.Unlock()
 	return LineLogNamespace{
 		Namespace: namespace,
 		Log:       LL(),
 	}
 }
 
+// Add a log line and push it to subscribers. Once Cap is reached the oldest
+// line is dropped, or written to the spill file when there is one.
+func (l *LineLog) Add(line LogLineLog) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID++
+	line.ID = l.nextID
+	if l.Cap <= 0 || len(l.logs) < l.Cap {
+		l.logs = append(l.logs, line)
+	} else {
+		l.spillLine(l.logs[l.start])
+		l.logs[l.start] = line
+		l.start = (l.start + 1) % len(l.logs)
+	}
+	for _, sink := range l.sinks {
+		// Nowhere to report a failed write
+		sink.WriteLine(line)
+	}
+	for ch := range l.subs {
+		// Slow subscribers miss lines rather than block logging, they can
+		// catch up with Lines
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
+
+// AddSink to receive every line added from now on
+func (l *LineLog) AddSink(sink LogSink) {
+	l.mu.Lock()
+	l.sinks = append(l.sinks, sink)
+	l.mu.Unlock()
+}
+
+// Close the sinks and spill file, call before quitting
+func (l *LineLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var err error
+	for _, sink := range l.sinks {
+		if e := sink.Close(); e != nil {
+			err = e
+		}
+	}
+	l.sinks = nil
+	if l.spill != nil {
+		l.spill.file.Close()
+		l.spill = nil
+	}
+	return err
+}
+
+// Lines is a copy of the log lines held in memory, oldest first
+func (l *LineLog) Lines() []LogLineLog {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.lines()
+}
+
+func (l *LineLog) lines() []LogLineLog {
+	lines := make([]LogLineLog, 0, len(l.logs))
+	lines = append(lines, l.logs[l.start:]...)
+	return append(lines, l.logs[:l.start]...)
+}
+
+// SetCap changes how many lines are kept in memory, lines over the new cap
+// are spilled or dropped
+func (l *LineLog) SetCap(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := l.lines()
+	if over := len(lines) - n; n > 0 && over > 0 {
+		for _, line := range lines[:over] {
+			l.spillLine(line)
+		}
+		lines = lines[over:]
+	}
+	l.Cap = n
+	l.logs = lines
+	l.start = 0
+}
+
+// Len is the number of log lines
+func (l *LineLog) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.logs)
+}
+
+// Subscribe returns a channel that receives each new log line
+func (l *LineLog) Subscribe() chan LogLineLog {
+	ch := make(chan LogLineLog, 256)
+	l.mu.Lock()
+	l.subs[ch] = struct{}{}
+	l.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops and closes the channel
+func (l *LineLog) Unsubscribe(ch chan LogLineLog) {
+	l.mu.Lock()
+	if _, ok := l.subs[ch]; ok {
+		delete(l.subs, ch)
+		close(ch)
+	}
+	l.mu.Unlock()
+}
+
+// Print a line at the given level
+func (l *LineLog) Print(namespace string, level LogLineLevel, msg string) {
+	l.Add(LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: level})
+}
+
 func (l *LineLog) Debug(namespace string, msg string) {
-	l.Logs = append(l.Logs, LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogDebug})
+	l.Add(LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogDebug})
 }
 
 func (l *LineLog) Info(namespace string, msg string) {
-	l.Logs = append(l.Logs, LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogInfo})
+	l.Add(LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogInfo})
 }
 
 func (l *LineLog) Warn(namespace string, msg string) {
-	l.Logs = append(l.Logs, LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogWarn})
+	l.Add(LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogWarn})
 }
 
 func (l *LineLog) Error(namespace string, msg string) {
-	l.Logs = append(l.Logs, LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogError})
+	l.Add(LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogError})
 }
 
 func (l *LineLog) Fatal(namespace string, msg string) {
-	l.Logs = append(l.Logs, LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogFatal})
+	l.Add(LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogFatal})
+	l.Close()
 	os.Exit(-1)
 }
 
 func (l *LineLog) Panic(namespace string, msg string) {
-	l.Logs = append(l.Logs, LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogPanic})
+	l.Add(LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: msg, Level: LogPanic})
 	panic(msg)
 }
 
 func (l *LineLog) Err(namespace string, err error) {
-	l.Logs = append(l.Logs, LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: err.Error(), Level: LogError})
+	l.Add(LogLineLog{Namespace: namespace, Timestamp: time.Now(), Message: err.Error(), Level: LogError})
 }
 
 type LineLogNamespace struct {
@@ -103,6 +271,25 @@ type LineLogNamespace struct {
 	Log       *LineLog
 }
 
+// Print a line at the given level
+func (n *LineLogNamespace) Print(level LogLineLevel, msg string) {
+	n.Log.Print(n.Namespace, level, msg)
+}
+
+// PrintFields logs a structured line along with its parsed fields
+func (n *LineLogNamespace) PrintFields(level LogLineLevel, msg string, fields map[string]string) {
+	n.Add(LogLineLog{Message: msg, Level: level, Fields: fields})
+}
+
+// Add a line in this namespace, the timestamp is set if empty
+func (n *LineLogNamespace) Add(line LogLineLog) {
+	line.Namespace = n.Namespace
+	if line.Timestamp.IsZero() {
+		line.Timestamp = time.Now()
+	}
+	n.Log.Add(line)
+}
+
 func (n *LineLogNamespace) Debug(msg string) {
 	n.Log.Debug(n.Namespace, msg)
 }
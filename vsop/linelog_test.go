@@ -0,0 +1,74 @@
+package vsop
+
+import (
+	"reflect"
+	"testing"
+)
+
+func messages(lines []LogLineLog) []string {
+	var msgs []string
+	for _, line := range lines {
+		msgs = append(msgs, line.Message)
+	}
+	return msgs
+}
+
+func addLines(l *LineLog, msgs ...string) {
+	for _, msg := range msgs {
+		l.Add(LogLineLog{Message: msg})
+	}
+}
+
+func TestLineLogWraparound(t *testing.T) {
+	tests := []struct {
+		cap  int
+		add  []string
+		want []string
+	}{
+		{3, []string{"a", "b"}, []string{"a", "b"}},
+		{3, []string{"a", "b", "c"}, []string{"a", "b", "c"}},
+		{3, []string{"a", "b", "c", "d"}, []string{"b", "c", "d"}},
+		{3, []string{"a", "b", "c", "d", "e", "f", "g"}, []string{"e", "f", "g"}},
+		{1, []string{"a", "b"}, []string{"b"}},
+		// No cap
+		{0, []string{"a", "b", "c", "d"}, []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		l := &LineLog{Cap: tt.cap}
+		addLines(l, tt.add...)
+		if got := messages(l.Lines()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("cap %d, add %v: Lines() = %v, want %v", tt.cap, tt.add, got, tt.want)
+		}
+	}
+}
+
+func TestLineLogSetCap(t *testing.T) {
+	tests := []struct {
+		cap    int
+		add    []string
+		newCap int
+		more   []string
+		want   []string
+	}{
+		// Shrinking drops the oldest, also after the buffer has wrapped
+		{5, []string{"a", "b", "c", "d"}, 2, nil, []string{"c", "d"}},
+		{3, []string{"a", "b", "c", "d", "e"}, 2, nil, []string{"d", "e"}},
+		{3, []string{"a", "b", "c", "d", "e"}, 2, []string{"f"}, []string{"e", "f"}},
+		// Growing keeps every line and wraps at the new cap
+		{3, []string{"a", "b", "c", "d"}, 4, []string{"e", "f"}, []string{"c", "d", "e", "f"}},
+		// The same cap only puts the lines back in order
+		{3, []string{"a", "b", "c", "d"}, 3, []string{"e"}, []string{"c", "d", "e"}},
+		// No cap keeps everything from then on
+		{2, []string{"a", "b", "c"}, 0, []string{"d", "e"}, []string{"b", "c", "d", "e"}},
+		{0, []string{"a", "b", "c"}, 2, []string{"d"}, []string{"c", "d"}},
+	}
+	for _, tt := range tests {
+		l := &LineLog{Cap: tt.cap}
+		addLines(l, tt.add...)
+		l.SetCap(tt.newCap)
+		addLines(l, tt.more...)
+		if got := messages(l.Lines()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("cap %d -> %d, add %v then %v: Lines() = %v, want %v", tt.cap, tt.newCap, tt.add, tt.more, got, tt.want)
+		}
+	}
+}
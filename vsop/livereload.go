@@ -0,0 +1,135 @@
+package vsop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	eventsPath     = reservedPath + "events"
+	liveReloadPath = reservedPath + "livereload.js"
+)
+
+// liveReloadURL is the script for a page served after build, the page only
+// reloads for a different build
+func liveReloadURL(build int, errorPage bool) string {
+	url := liveReloadPath + "?build=" + strconv.Itoa(build)
+	if errorPage {
+		url += "&error=1"
+	}
+	return url
+}
+
+// eventsHandler streams build and run events as Server-Sent Events
+func (p *Proxy) eventsHandler(res http.ResponseWriter, req *http.Request) {
+	flusher, ok := res.(http.Flusher)
+	if !ok || p.events == nil {
+		http.Error(res, "event stream not supported", http.StatusNotImplemented)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := p.events.Subscribe()
+	defer p.events.Unsubscribe(ch)
+
+	// Builds that finished before the page connected are sent as the last
+	// build's result, so the page can tell it missed one
+	if build := p.builder.Builds(); build > 0 {
+		typ := EventBuildSuccess
+		if p.builder.Errors() != "" {
+			typ = EventBuildFailed
+		}
+		data, _ := json.Marshal(Event{Type: typ, Time: time.Now(), Build: build})
+		fmt.Fprintf(res, "event: %s\ndata: %s\n\n", typ, data)
+		flusher.Flush()
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(res, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(res, ": ping\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		case <-p.closing:
+			return
+		}
+	}
+}
+
+func (p *Proxy) liveReloadHandler(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/javascript")
+	res.Header().Set("Cache-Control", "no-store")
+	build, _ := strconv.Atoi(req.URL.Query().Get("build"))
+	errorPage := req.URL.Query().Get("error") == "1"
+	fmt.Fprintf(res, liveReloadScript, build, errorPage)
+}
+
+// injectLiveReload adds the reload script to HTML responses from the app
+func (p *Proxy) injectLiveReload(res *http.Response) error {
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		return nil
+	}
+	if res.Header.Get("Content-Encoding") != "" || res.Body == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	tag := []byte(`<script src="` + liveReloadURL(p.builder.Builds(), false) + `"></script>`)
+	if i := bytes.LastIndex(bytes.ToLower(body), []byte("</body>")); i >= 0 {
+		body = append(body[:i], append(tag, body[i:]...)...)
+	} else {
+		body = append(body, tag...)
+	}
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// Reload once a build other than the one the page was served after succeeds.
+// The error page also reloads after a failed build, to show the new errors.
+// Formatted with the page's build and whether it's the error page.
+const liveReloadScript = `(function() {
+	if (!window.EventSource || window.__vsopLiveReload) { return; }
+	window.__vsopLiveReload = true;
+	var build = %d, errorPage = %t;
+	var es = new EventSource("` + eventsPath + `");
+	var check = function(e) {
+		var event = JSON.parse(e.data);
+		if (event.build !== build && (e.type === "` + EventBuildSuccess + `" || errorPage)) {
+			es.close();
+			location.reload();
+		}
+	};
+	es.addEventListener("` + EventBuildSuccess + `", check);
+	es.addEventListener("` + EventBuildFailed + `", check);
+})();
+`
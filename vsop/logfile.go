@@ -0,0 +1,112 @@
+package vsop
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How many previous session log files to keep
+const logFileKeep = 5
+
+// LogSink receives every log line as it is added
+type LogSink interface {
+	WriteLine(line LogLineLog) error
+	Close() error
+}
+
+// LogFileSink writes log lines to a file as plain text or JSON Lines
+type LogFileSink struct {
+	file *os.File
+	json bool
+}
+
+type logFileRecord struct {
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+	// Fields of a structured app log line
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// NewLogFileSink starts a new session log at path, the log from the last
+// session is moved to path.1, path.1 to path.2 and so on. format is "text" or
+// "json".
+func NewLogFileSink(path string, format string) (*LogFileSink, error) {
+	if format != "text" && format != "json" {
+		return nil, errors.Errorf("log file format %q, must be text or json", format)
+	}
+
+	if err := rotateLogFiles(path); err != nil {
+		return nil, errors.Wrap(err, "rotate log files")
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "log file")
+	}
+
+	return &LogFileSink{file: f, json: format == "json"}, nil
+}
+
+// RotatedLogFiles are the names rotateLogFiles keeps previous sessions of the
+// log file at path under
+func RotatedLogFiles(path string) []string {
+	files := make([]string, logFileKeep)
+	for i := range files {
+		files[i] = fmt.Sprintf("%s.%d", path, i+1)
+	}
+	return files
+}
+
+func rotateLogFiles(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", path, logFileKeep))
+	for i := logFileKeep - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(from); err == nil {
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(path, path+".1")
+}
+
+func (s *LogFileSink) WriteLine(line LogLineLog) error {
+	if s.json {
+		data, err := json.Marshal(logFileRecord{
+			Time:      line.Timestamp,
+			Namespace: line.Namespace,
+			Level:     line.Level.String(),
+			Message:   line.Message,
+			Fields:    line.Fields,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = s.file.Write(append(data, '\n'))
+		return err
+	}
+
+	_, err := fmt.Fprintf(
+		s.file,
+		"%s %s %-5s %s\n",
+		line.Timestamp.Format("2006-01-02 15:04:05.000"),
+		line.Namespace,
+		line.Level,
+		line.Message,
+	)
+	return err
+}
+
+func (s *LogFileSink) Close() error {
+	return s.file.Close()
+}
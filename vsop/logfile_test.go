@@ -0,0 +1,86 @@
+package vsop
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRotateLogFiles(t *testing.T) {
+	// Files are named by suffix, "" is the log file itself, and hold the
+	// session they came from
+	tests := []struct {
+		name   string
+		before map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "no log file",
+			before: map[string]string{},
+			want:   map[string]string{},
+		},
+		{
+			name:   "first rotation",
+			before: map[string]string{"": "s1"},
+			want:   map[string]string{".1": "s1"},
+		},
+		{
+			name:   "shifted up",
+			before: map[string]string{"": "s3", ".1": "s2", ".2": "s1"},
+			want:   map[string]string{".1": "s3", ".2": "s2", ".3": "s1"},
+		},
+		{
+			name:   "oldest dropped",
+			before: map[string]string{"": "s6", ".1": "s5", ".2": "s4", ".3": "s3", ".4": "s2", ".5": "s1"},
+			want:   map[string]string{".1": "s6", ".2": "s5", ".3": "s4", ".4": "s3", ".5": "s2"},
+		},
+		{
+			name:   "gap in the old files",
+			before: map[string]string{"": "s4", ".1": "s3", ".3": "s1"},
+			want:   map[string]string{".1": "s4", ".2": "s3", ".4": "s1"},
+		},
+		{
+			// Old files are left alone when there's nothing to rotate
+			name:   "only old files",
+			before: map[string]string{".1": "s1"},
+			want:   map[string]string{".1": "s1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "vsop")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "vsop.log")
+			for suffix, session := range tt.before {
+				if err := ioutil.WriteFile(path+suffix, []byte(session), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := rotateLogFiles(path); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]string)
+			infos, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, info := range infos {
+				data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[info.Name()[len("vsop.log"):]] = string(data)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package vsop
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// logSpill is an on-disk segment of lines pushed out of the ring buffer, one
+// JSON object per line
+type logSpill struct {
+	path  string
+	file  *os.File
+	enc   *json.Encoder
+	count int
+
+	// searchMu guards the matches of the last search, which are kept so the
+	// next search with the same pattern only reads the lines spilled since
+	searchMu sync.Mutex
+	pattern  string
+	scanned  int
+	offset   int64
+	matches  []LogLineLog
+}
+
+// SetSpill writes lines that fall out of memory to path so they can still be
+// searched, the file is truncated. An empty path turns spilling off.
+func (l *LineLog) SetSpill(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.spill != nil {
+		l.spill.file.Close()
+		l.spill = nil
+	}
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "log spill file")
+	}
+	l.spill = &logSpill{path: path, file: f, enc: json.NewEncoder(f)}
+	return nil
+}
+
+// Spilled is how many lines have been written to the spill file
+func (l *LineLog) Spilled() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.spill == nil {
+		return 0
+	}
+	return l.spill.count
+}
+
+// spillLine must be called with mu held
+func (l *LineLog) spillLine(line LogLineLog) {
+	if l.spill == nil {
+		return
+	}
+	if err := l.spill.enc.Encode(line); err != nil {
+		// Can't log about it without coming back here
+		return
+	}
+	l.spill.count++
+}
+
+// Search returns every line with a message matching re, spilled lines
+// first then lines still in memory
+func (l *LineLog) Search(re *regexp.Regexp) ([]LogLineLog, error) {
+	l.mu.RLock()
+	spill, count := l.spill, 0
+	if spill != nil {
+		count = spill.count
+	}
+	lines := l.lines()
+	l.mu.RUnlock()
+
+	var found []LogLineLog
+	if spill != nil {
+		var err error
+		// Only read as far as the snapshot of memory so nothing shows twice
+		if found, err = spill.search(re, count); err != nil {
+			return found, err
+		}
+	}
+
+	for _, line := range lines {
+		if re.MatchString(line.Message) {
+			found = append(found, line)
+		}
+	}
+	return found, nil
+}
+
+// search returns the first count spilled lines matching re, reading on from
+// where the last search for the same pattern stopped
+func (s *logSpill) search(re *regexp.Regexp, count int) ([]LogLineLog, error) {
+	s.searchMu.Lock()
+	defer s.searchMu.Unlock()
+
+	if re.String() != s.pattern || count < s.scanned {
+		s.pattern, s.scanned, s.offset, s.matches = re.String(), 0, 0, nil
+	}
+	if s.scanned < count {
+		f, err := os.Open(s.path)
+		if err != nil {
+			return nil, errors.Wrap(err, "search log spill file")
+		}
+		defer f.Close()
+		if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+			return nil, errors.Wrap(err, "search log spill file")
+		}
+
+		reader := bufio.NewReaderSize(f, 64*1024)
+		for s.scanned < count {
+			data, err := reader.ReadBytes('\n')
+			if err == io.EOF {
+				// A line still being written is read next time
+				break
+			} else if err != nil {
+				return nil, errors.Wrap(err, "search log spill file")
+			}
+			s.offset += int64(len(data))
+			s.scanned++
+
+			var line LogLineLog
+			if err := json.Unmarshal(data, &line); err != nil {
+				continue
+			}
+			if re.MatchString(line.Message) {
+				s.matches = append(s.matches, line)
+			}
+		}
+	}
+
+	// Capped so the caller's appends don't write into the cache
+	return s.matches[:len(s.matches):len(s.matches)], nil
+}
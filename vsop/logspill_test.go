@@ -0,0 +1,89 @@
+package vsop
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestLineLogSearchSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsop-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		cap     int
+		add     []string
+		pattern string
+		want    []string
+	}{
+		// Nothing spilled yet
+		{3, []string{"a1", "b1", "a2"}, "a", []string{"a1", "a2"}},
+		// Spilled lines come first, each line once
+		{3, []string{"a1", "b1", "a2", "a3", "b2"}, "a", []string{"a1", "a2", "a3"}},
+		{2, []string{"a1", "a2", "a3", "a4", "a5", "a6", "a7"}, "a", []string{"a1", "a2", "a3", "a4", "a5", "a6", "a7"}},
+		{2, []string{"a1", "b1", "a2", "b2", "a3"}, "b", []string{"b1", "b2"}},
+		{2, []string{"a1", "b1", "a2"}, "c", nil},
+	}
+	for i, tt := range tests {
+		l := &LineLog{Cap: tt.cap}
+		if err := l.SetSpill(filepath.Join(dir, fmt.Sprintf("spill%d", i))); err != nil {
+			t.Fatal(err)
+		}
+		addLines(l, tt.add...)
+		re := regexp.MustCompile(tt.pattern)
+		got, err := l.Search(re)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msgs := messages(got); !reflect.DeepEqual(msgs, tt.want) {
+			t.Errorf("cap %d, add %v: Search(%q) = %v, want %v", tt.cap, tt.add, tt.pattern, msgs, tt.want)
+		}
+		if want := len(tt.add) - tt.cap; want > 0 && l.Spilled() != want {
+			t.Errorf("cap %d, add %v: Spilled() = %d, want %d", tt.cap, tt.add, l.Spilled(), want)
+		}
+		l.Close()
+	}
+}
+
+// TestLineLogSearchAgain searches again after more lines are spilled, the
+// matches kept from the last search aren't repeated
+func TestLineLogSearchAgain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsop-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := &LineLog{Cap: 2}
+	defer l.Close()
+	if err := l.SetSpill(filepath.Join(dir, "spill")); err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile("a")
+
+	steps := []struct {
+		add  []string
+		want []string
+	}{
+		{[]string{"a1", "b1", "a2"}, []string{"a1", "a2"}},
+		{[]string{"a3", "b2"}, []string{"a1", "a2", "a3"}},
+		{[]string{"b3", "b4", "a4"}, []string{"a1", "a2", "a3", "a4"}},
+	}
+	for _, step := range steps {
+		addLines(l, step.add...)
+		got, err := l.Search(re)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msgs := messages(got); !reflect.DeepEqual(msgs, step.want) {
+			t.Errorf("after %v: Search = %v, want %v", step.add, msgs, step.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package vsop
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Package is what the package graph needs to know from go list
+type Package struct {
+	ImportPath   string
+	Dir          string
+	Imports      []string
+	TestImports  []string
+	XTestImports []string
+}
+
+// PackageGraph is the import graph of the packages under a directory
+type PackageGraph struct {
+	dir string
+	// modFile is the go.mod the packages are in, empty outside a module
+	modFile string
+
+	// loadMu lets one load run at a time
+	loadMu sync.Mutex
+	loaded bool
+	// modTime is modFile's modification time when the graph was loaded
+	modTime time.Time
+
+	mu    sync.Mutex
+	pkgs  map[string]*Package
+	byDir map[string]string
+	// importedBy is the reverse of Imports, test imports aren't included
+	importedBy map[string][]string
+}
+
+func NewPackageGraph(dir string) *PackageGraph {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	g := &PackageGraph{dir: dir}
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			g.modFile = filepath.Join(d, "go.mod")
+			break
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	return g
+}
+
+// Load runs go list on every package under the directory
+func (g *PackageGraph) Load() error {
+	g.loadMu.Lock()
+	defer g.loadMu.Unlock()
+	return g.load()
+}
+
+func (g *PackageGraph) load() error {
+	modTime := g.modFileTime()
+	cmd := exec.Command("go", "list", "-e", "-json", "./...")
+	cmd.Dir = g.dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return errors.Wrap(err, "go list: "+stderr.String())
+	}
+
+	pkgs := make(map[string]*Package)
+	byDir := make(map[string]string)
+	importedBy := make(map[string][]string)
+	dec := json.NewDecoder(bytes.NewReader(output))
+	for {
+		p := &Package{}
+		if err := dec.Decode(p); err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "go list")
+		}
+		pkgs[p.ImportPath] = p
+		byDir[p.Dir] = p.ImportPath
+	}
+	for _, p := range pkgs {
+		for _, imp := range p.Imports {
+			if _, ok := pkgs[imp]; ok {
+				importedBy[imp] = append(importedBy[imp], p.ImportPath)
+			}
+		}
+	}
+
+	g.mu.Lock()
+	g.pkgs, g.byDir, g.importedBy = pkgs, byDir, importedBy
+	g.mu.Unlock()
+	g.loaded = true
+	g.modTime = modTime
+	return nil
+}
+
+func (g *PackageGraph) modFileTime() time.Time {
+	if g.modFile == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(g.modFile)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// stale is true when the graph has to be loaded again to be right about the
+// changed files: go.mod changed, a package directory was added or removed, or
+// a file imports something its package didn't
+func (g *PackageGraph) stale(files []string) bool {
+	if !g.loaded || !g.modFileTime().Equal(g.modTime) {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, file := range files {
+		if filepath.Ext(file) != ".go" {
+			continue
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			continue
+		}
+		pkg, known := g.byDir[filepath.Dir(abs)]
+		_, err = os.Stat(filepath.Dir(abs))
+		if exists := err == nil; exists != known {
+			return true
+		}
+		if known && !g.importsKnown(g.pkgs[pkg], abs) {
+			return true
+		}
+	}
+	return false
+}
+
+// importsKnown is false when file imports a package that isn't in p's
+// imports, a file that can't be parsed has nothing to add
+func (g *PackageGraph) importsKnown(p *Package, file string) bool {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+	if err != nil {
+		return true
+	}
+	imports := p.Imports
+	if strings.HasSuffix(file, "_test.go") {
+		imports = append(append(append([]string(nil), imports...), p.TestImports...), p.XTestImports...)
+	}
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || path == "C" {
+			continue
+		}
+		found := false
+		for _, imp := range imports {
+			if imp == path {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// PackageOf is the import path of the package holding file, empty when it
+// isn't in the graph
+func (g *PackageGraph) PackageOf(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return ""
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.byDir[filepath.Dir(abs)]
+}
+
+// Affected works out the packages holding the changed files, and every
+// package they affect: the changed packages, the packages that import them
+// directly or indirectly, and the packages whose tests import any of those.
+// The graph is only loaded again when the changes make it stale. Both lists
+// are sorted.
+func (g *PackageGraph) Affected(files []string) (changed []string, affected []string, err error) {
+	g.loadMu.Lock()
+	if g.stale(files) {
+		err = g.load()
+	}
+	g.loadMu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if pkg := g.PackageOf(file); pkg != "" && !seen[pkg] {
+			seen[pkg] = true
+			changed = append(changed, pkg)
+		}
+	}
+	sort.Strings(changed)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Everything that imports a changed package, all the way up
+	built := make(map[string]bool)
+	queue := append([]string(nil), changed...)
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		if built[pkg] {
+			continue
+		}
+		built[pkg] = true
+		queue = append(queue, g.importedBy[pkg]...)
+	}
+
+	for pkg := range built {
+		affected = append(affected, pkg)
+	}
+	for path, p := range g.pkgs {
+		if built[path] {
+			continue
+		}
+		for _, imp := range append(append([]string(nil), p.TestImports...), p.XTestImports...) {
+			if built[imp] {
+				affected = append(affected, path)
+				break
+			}
+		}
+	}
+	sort.Strings(affected)
+	return changed, affected, nil
+}
@@ -0,0 +1,198 @@
+package vsop
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writePackageGraphModule writes a module where b imports a, c imports b, d's
+// tests import c and e stands alone
+func writePackageGraphModule(t *testing.T) string {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go isn't installed")
+	}
+	dir, err := ioutil.TempDir("", "vsop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"go.mod":      "module example.com/m\n\ngo 1.16\n",
+		"a/a.go":      "package a\n",
+		"b/b.go":      "package b\n\nimport _ \"example.com/m/a\"\n",
+		"c/c.go":      "package c\n\nimport _ \"example.com/m/b\"\n",
+		"d/d.go":      "package d\n",
+		"d/d_test.go": "package d\n\nimport _ \"example.com/m/c\"\n",
+		"e/e.go":      "package e\n",
+		"e/e_test.go": "package e_test\n\nimport _ \"example.com/m/e\"\n",
+	}
+	for name, src := range files {
+		writeFile(t, filepath.Join(dir, name), src)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path string, src string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPackageGraphAffected(t *testing.T) {
+	dir := writePackageGraphModule(t)
+	defer os.RemoveAll(dir)
+	g := NewPackageGraph(dir)
+
+	tests := []struct {
+		files    []string
+		changed  []string
+		affected []string
+	}{
+		{
+			files:    []string{"a/a.go"},
+			changed:  []string{"example.com/m/a"},
+			affected: []string{"example.com/m/a", "example.com/m/b", "example.com/m/c", "example.com/m/d"},
+		},
+		{
+			files:    []string{"c/c.go"},
+			changed:  []string{"example.com/m/c"},
+			affected: []string{"example.com/m/c", "example.com/m/d"},
+		},
+		{
+			files:    []string{"d/d_test.go"},
+			changed:  []string{"example.com/m/d"},
+			affected: []string{"example.com/m/d"},
+		},
+		{
+			// An external test package counts as the package it tests
+			files:    []string{"e/e_test.go", "e/e.go"},
+			changed:  []string{"example.com/m/e"},
+			affected: []string{"example.com/m/e"},
+		},
+		{
+			files:    []string{"b/b.go", "e/e.go"},
+			changed:  []string{"example.com/m/b", "example.com/m/e"},
+			affected: []string{"example.com/m/b", "example.com/m/c", "example.com/m/d", "example.com/m/e"},
+		},
+		{
+			files: []string{"README.md"},
+		},
+	}
+	for _, tt := range tests {
+		var files []string
+		for _, f := range tt.files {
+			files = append(files, filepath.Join(dir, f))
+		}
+		changed, affected, err := g.Affected(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(changed, tt.changed) || !reflect.DeepEqual(affected, tt.affected) {
+			t.Errorf("Affected(%v) = %v, %v, want %v, %v", tt.files, changed, affected, tt.changed, tt.affected)
+		}
+	}
+}
+
+func TestPackageGraphStale(t *testing.T) {
+	tests := []struct {
+		name string
+		// change is made after the graph is loaded, in the module's directory
+		change func(t *testing.T, dir string)
+		files  []string
+		want   bool
+	}{
+		{
+			name:   "unchanged file",
+			change: func(t *testing.T, dir string) {},
+			files:  []string{"a/a.go", "d/d_test.go"},
+		},
+		{
+			name:   "not a go file",
+			change: func(t *testing.T, dir string) { writeFile(t, filepath.Join(dir, "f/notes.txt"), "") },
+			files:  []string{"f/notes.txt"},
+		},
+		{
+			name: "import already known",
+			change: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "c/c2.go"), "package c\n\nimport _ \"example.com/m/b\"\n")
+			},
+			files: []string{"c/c2.go"},
+		},
+		{
+			name: "test import already known",
+			change: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "d/d2_test.go"), "package d\n\nimport _ \"example.com/m/c\"\n")
+			},
+			files: []string{"d/d2_test.go"},
+		},
+		{
+			name: "new import",
+			change: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "a/a.go"), "package a\n\nimport _ \"example.com/m/e\"\n")
+			},
+			files: []string{"a/a.go"},
+			want:  true,
+		},
+		{
+			// Only tests may import what the package doesn't
+			name: "test import in a package file",
+			change: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "d/d.go"), "package d\n\nimport _ \"example.com/m/c\"\n")
+			},
+			files: []string{"d/d.go"},
+			want:  true,
+		},
+		{
+			name:   "new package",
+			change: func(t *testing.T, dir string) { writeFile(t, filepath.Join(dir, "f/f.go"), "package f\n") },
+			files:  []string{"f/f.go"},
+			want:   true,
+		},
+		{
+			name:   "removed package",
+			change: func(t *testing.T, dir string) { os.RemoveAll(filepath.Join(dir, "e")) },
+			files:  []string{"e/e.go"},
+			want:   true,
+		},
+		{
+			name: "go.mod changed",
+			change: func(t *testing.T, dir string) {
+				later := time.Now().Add(time.Minute)
+				if err := os.Chtimes(filepath.Join(dir, "go.mod"), later, later); err != nil {
+					t.Fatal(err)
+				}
+			},
+			files: []string{"a/a.go"},
+			want:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writePackageGraphModule(t)
+			defer os.RemoveAll(dir)
+			g := NewPackageGraph(dir)
+			var files []string
+			for _, f := range tt.files {
+				files = append(files, filepath.Join(dir, f))
+			}
+			if !g.stale(files) {
+				t.Fatal("stale before the graph is loaded")
+			}
+			if err := g.Load(); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.change(t, dir)
+			if got := g.stale(files); got != tt.want {
+				t.Errorf("stale(%v) = %v, want %v", tt.files, got, tt.want)
+			}
+		})
+	}
+}
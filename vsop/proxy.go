@@ -2,7 +2,9 @@ package vsop
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -10,27 +12,57 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
+// Requests under this path are handled by VSOP and never reach the app
+const reservedPath = "/__vsop/"
+
+const statusPath = reservedPath + "status"
+
+// How long Close waits for requests in flight
+const proxyCloseWait = 2 * time.Second
+
 type Proxy struct {
 	listener net.Listener
 	proxy    *httputil.ReverseProxy
 	builder  *Builder
 	runner   *Runner
 	to       *url.URL
+	log      LineLogNamespace
+	events   *EventHub
+	// How long to hold a request while a build is running
+	buildWait time.Duration
+	ready     *Readiness
+	readyMu   sync.Mutex
+	// readyCmd is the app process that last passed the readiness check
+	readyCmd *exec.Cmd
+	server   *http.Server
+	// logWriter feeds the reverse proxy's error log to the line log, closing
+	// it stops the scanner
+	logWriter *io.PipeWriter
+	// closing ends the live reload event streams so Close doesn't wait on them
+	closing chan struct{}
 }
 
 func NewProxy(builder *Builder, runner *Runner) *Proxy {
 	return &Proxy{
 		builder: builder,
 		runner:  runner,
+		closing: make(chan struct{}),
 	}
 }
 
+// SetEvents used for the live reload event stream
+func (p *Proxy) SetEvents(events *EventHub) {
+	p.events = events
+}
+
 func (p *Proxy) Run(config *Config, l LineLogNamespace) error {
 
 	// create our reverse proxy
@@ -39,29 +71,32 @@ func (p *Proxy) Run(config *Config, l LineLogNamespace) error {
 		return err
 	}
 	p.proxy = httputil.NewSingleHostReverseProxy(url)
+	p.log = l
+	p.buildWait = config.BuildWait
+	p.ready, err = NewReadiness(url, config.HealthPath, config.StartTimeout)
+	if err != nil {
+		return err
+	}
 
-	r, w := io.Pipe()
-	p.proxy.ErrorLog = log.New(w, "", 0)
-
-	go func() {
-		for true {
-			scanner := bufio.NewScanner(r)
-			for scanner.Scan() {
-				l.Info(scanner.Text())
-			}
-			if err := scanner.Err(); err != nil {
-				if err != io.EOF {
-					l.Err(errors.Wrap(err, "proxy log scanner"))
-				}
-			}
+	if config.LiveReload {
+		director := p.proxy.Director
+		p.proxy.Director = func(req *http.Request) {
+			director(req)
+			// Compressed responses can't have the script injected
+			req.Header.Del("Accept-Encoding")
 		}
-
-		l.Debug("Proxy reader done\n")
-	}()
+		p.proxy.ModifyResponse = p.injectLiveReload
+	}
 
 	p.to = url
 
-	server := http.Server{Handler: http.HandlerFunc(p.defaultHandler)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusPath, p.statusHandler)
+	mux.HandleFunc(eventsPath, p.eventsHandler)
+	mux.HandleFunc(liveReloadPath, p.liveReloadHandler)
+	mux.HandleFunc("/", p.defaultHandler)
+	server := &http.Server{Handler: mux}
+	p.server = server
 
 	if config.CertFile != "" && config.KeyFile != "" {
 		cer, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
@@ -82,52 +117,132 @@ func (p *Proxy) Run(config *Config, l LineLogNamespace) error {
 		}
 	}
 
+	r, w := io.Pipe()
+	p.logWriter = w
+	p.proxy.ErrorLog = log.New(w, "", 0)
+
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			l.Info(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			l.Err(errors.Wrap(err, "proxy log scanner"))
+		}
+
+		l.Debug("Proxy reader done\n")
+	}()
+
 	go server.Serve(p.listener)
 
 	return nil
 }
 
+// Close stops the server, waiting a moment for requests in flight, and the
+// error log scanner
 func (p *Proxy) Close() error {
-	return p.listener.Close()
+	close(p.closing)
+	ctx, cancel := context.WithTimeout(context.Background(), proxyCloseWait)
+	defer cancel()
+	err := p.server.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		err = p.server.Close()
+	}
+	p.logWriter.Close()
+	return err
 }
 
 func (p *Proxy) defaultHandler(res http.ResponseWriter, req *http.Request) {
-	errors := p.builder.Errors()
-	if len(errors) > 0 {
-		res.Write([]byte(errors))
-	} else {
-		if !p.runner.IsRunning() {
-			p.runner.Run()
-			// Let the app get going
-			p.dialTarget("http://localhost:" + p.to.Port())
+	if p.builder.Building() {
+		p.log.Debugf("Holding %s %s until the build finishes", req.Method, req.URL.Path)
+		if !p.builder.Wait(p.buildWait) {
+			p.errorPage(res, http.StatusServiceUnavailable, "Build still running",
+				fmt.Sprintf("Gave up waiting for the build after %s", p.buildWait))
+			return
 		}
-		if strings.ToLower(req.Header.Get("Upgrade")) == "websocket" || strings.ToLower(req.Header.Get("Accept")) == "text/event-stream" {
-			proxyWebsocket(res, req, p.to)
-		} else {
-			p.proxy.ServeHTTP(res, req)
+	}
+
+	buildErrors := p.builder.Errors()
+	if len(buildErrors) > 0 {
+		p.errorPage(res, http.StatusInternalServerError, "Build failed", buildErrors)
+		return
+	}
+
+	if !p.runner.IsRunning() {
+		if _, err := p.runner.Run(); err != nil {
+			p.errorPage(res, http.StatusServiceUnavailable, "App failed to start", err.Error())
+			return
 		}
 	}
+	if err := p.waitReady(); err != nil {
+		p.errorPage(res, http.StatusServiceUnavailable, "App not ready", err.Error())
+		return
+	}
+	if strings.ToLower(req.Header.Get("Upgrade")) == "websocket" || strings.ToLower(req.Header.Get("Accept")) == "text/event-stream" {
+		p.proxyWebsocket(res, req)
+	} else {
+		p.proxy.ServeHTTP(res, req)
+	}
 }
 
-// TODO: log errors
-func (p *Proxy) dialTarget(target string) {
-	conn, err := net.DialTimeout("tcp", p.to.Hostname()+":"+p.to.Port(), 5*time.Second)
-	if err, ok := err.(*net.OpError); ok && err.Timeout() {
-		// fmt.Printf("Timeout error: %s\n", err)
-		return
+func (p *Proxy) errorPage(res http.ResponseWriter, status int, title string, raw string) {
+	var diags []Diagnostic
+	var mods []ModuleError
+	if status == http.StatusInternalServerError {
+		diags = p.builder.Diagnostics()
+		mods = p.builder.ModuleErrors()
 	}
+	err := renderErrorPage(res, status, title, p.builder.Builds(), diags, mods, raw)
 	if err != nil {
-		// fmt.Printf("Error: %s\n", err)
-		return
+		p.log.Err(errors.Wrap(err, "render error page"))
+	}
+}
+
+// statusHandler reports the build and app state
+func (p *Proxy) statusHandler(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(res).Encode(map[string]interface{}{
+		"build":    p.builder.Builds(),
+		"building": p.builder.Building(),
+		"failed":   len(p.builder.Errors()) > 0,
+		"running":  p.runner.IsRunning(),
+	})
+}
+
+// waitReady blocks until the running app passes the readiness check, each
+// start of the app is only checked once. An app that never becomes ready is
+// killed.
+func (p *Proxy) waitReady() error {
+	p.readyMu.Lock()
+	defer p.readyMu.Unlock()
+
+	cmd := p.runner.Command()
+	if cmd == nil || cmd == p.readyCmd {
+		return nil
 	}
-	defer conn.Close()
+
+	took, err := p.ready.Wait(p.runner.IsRunning)
+	if err == ErrStartTimeOut {
+		p.log.Err(errors.Wrapf(err, "not ready after %s", took.Round(time.Millisecond)))
+		p.runner.Kill()
+		return err
+	}
+	if err != nil {
+		p.log.Err(errors.Wrap(err, "readiness"))
+		return err
+	}
+
+	p.readyCmd = cmd
+	p.log.Infof("App ready in %s", took.Round(time.Millisecond))
+	return nil
 }
 
-func proxyWebsocket(w http.ResponseWriter, r *http.Request, host *url.URL) {
-	d, err := net.Dial("tcp", host.Host)
+func (p *Proxy) proxyWebsocket(w http.ResponseWriter, r *http.Request) {
+	d, err := net.Dial("tcp", p.to.Host)
 	if err != nil {
 		http.Error(w, "Error contacting backend server.", 500)
-		fmt.Errorf("Error dialing websocket backend %s: %v", host, err)
+		p.log.Err(errors.Wrapf(err, "dialing websocket backend %s", p.to))
 		return
 	}
 	hj, ok := w.(http.Hijacker)
@@ -137,7 +252,7 @@ func proxyWebsocket(w http.ResponseWriter, r *http.Request, host *url.URL) {
 	}
 	nc, _, err := hj.Hijack()
 	if err != nil {
-		fmt.Errorf("Hijack error: %v", err)
+		p.log.Err(errors.Wrap(err, "websocket hijack"))
 		return
 	}
 	defer nc.Close()
@@ -145,7 +260,7 @@ func proxyWebsocket(w http.ResponseWriter, r *http.Request, host *url.URL) {
 
 	err = r.Write(d)
 	if err != nil {
-		fmt.Errorf("Error copying request to target: %v", err)
+		p.log.Err(errors.Wrap(err, "copying websocket request to target"))
 		return
 	}
 
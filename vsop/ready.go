@@ -0,0 +1,96 @@
+package vsop
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Backoff between readiness checks
+const (
+	readyMinBackoff = 20 * time.Millisecond
+	readyMaxBackoff = time.Second
+)
+
+// Readiness polls the app until it accepts connections, or answers on the
+// health path when one is set
+type Readiness struct {
+	to        *url.URL
+	healthURL string
+	timeout   time.Duration
+	client    *http.Client
+}
+
+func NewReadiness(to *url.URL, healthPath string, timeout time.Duration) (*Readiness, error) {
+	healthURL := ""
+	if healthPath != "" {
+		ref, err := url.Parse(healthPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "health path")
+		}
+		healthURL = to.ResolveReference(ref).String()
+	}
+
+	return &Readiness{
+		to:        to,
+		healthURL: healthURL,
+		timeout:   timeout,
+		client: &http.Client{
+			Timeout: time.Second,
+			// A redirect still means the app is up
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+// Wait until the app is ready, alive is checked between attempts so a crashed
+// app fails fast. Returns how long it took, or ErrStartTimeOut.
+func (r *Readiness) Wait(alive func() bool) (time.Duration, error) {
+	start := time.Now()
+	deadline := start.Add(r.timeout)
+	backoff := readyMinBackoff
+
+	for {
+		err := r.check()
+		if err == nil {
+			return time.Since(start), nil
+		}
+		if !alive() {
+			return time.Since(start), errors.Wrap(err, "app exited before it was ready")
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return time.Since(start), ErrStartTimeOut
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > readyMaxBackoff {
+			backoff = readyMaxBackoff
+		}
+	}
+}
+
+func (r *Readiness) check() error {
+	if r.healthURL == "" {
+		conn, err := net.DialTimeout("tcp", r.to.Host, time.Second)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	res, err := r.client.Get(r.healthURL)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return errors.Errorf("health check %s returned %s", r.healthURL, res.Status)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -20,9 +21,20 @@ type Runner struct {
 	bin       string
 	args      []string
 	writer    io.Writer
+	errWriter io.Writer
 	command   *exec.Cmd
+	// waited is closed once the command's Wait returns, ProcessState is only
+	// safe to read after that
+	waited    chan struct{}
 	starttime time.Time
 	log       LineLogNamespace
+	events    *EventHub
+	mu        sync.Mutex
+	// envFiles, envDefaults and envVars make up the app's environment, see
+	// Environ
+	envFiles    []string
+	envDefaults map[string]string
+	envVars     map[string]string
 }
 
 func NewRunner(bin string, logger LineLogNamespace, args ...string) *Runner {
@@ -30,17 +42,21 @@ func NewRunner(bin string, logger LineLogNamespace, args ...string) *Runner {
 		bin:       bin,
 		args:      args,
 		writer:    ioutil.Discard,
+		errWriter: ioutil.Discard,
 		starttime: time.Now(),
 		log:       logger,
 	}
 }
 
 func (r *Runner) Run() (*exec.Cmd, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if r.needsRefresh() {
-		r.Kill()
+		r.kill()
 	}
 
-	if r.command == nil || r.Exited() {
+	if r.command == nil || r.exited() {
 		err := r.runBin()
 		if err != nil {
 			r.log.Err(errors.Wrap(err, "runner run"))
@@ -54,20 +70,31 @@ func (r *Runner) Info() (os.FileInfo, error) {
 	return os.Stat(r.bin)
 }
 
+// SetEvents to publish app start and stop on
+func (r *Runner) SetEvents(events *EventHub) {
+	r.events = events
+}
+
 // SetWriter for stdout and errout
 func (r *Runner) SetWriter(writer io.Writer) {
 	r.writer = writer
+	r.errWriter = writer
+}
+
+// SetErrWriter for errout only, call after SetWriter
+func (r *Runner) SetErrWriter(writer io.Writer) {
+	r.errWriter = writer
 }
 
 // Kill process
 func (r *Runner) Kill() error {
-	if r.command != nil && r.command.Process != nil {
-		done := make(chan error)
-		go func() {
-			r.command.Wait()
-			close(done)
-		}()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.kill()
+}
 
+func (r *Runner) kill() error {
+	if r.command != nil && r.command.Process != nil {
 		//Trying a "soft" kill first
 		if runtime.GOOS == "windows" {
 			if err := r.command.Process.Kill(); err != nil {
@@ -83,35 +110,63 @@ func (r *Runner) Kill() error {
 			if err := r.command.Process.Kill(); err != nil {
 				r.log.Err(errors.Wrap(err, "hard kill process"))
 			}
-		case <-done:
+		case <-r.waited:
 		}
 		r.command = nil
+		r.events.Publish(EventKill, 0)
 	}
 
 	return nil
 }
 
 func (r *Runner) Exited() bool {
-	return r.command != nil && r.command.ProcessState != nil && r.command.ProcessState.Exited()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.exited()
+}
+
+func (r *Runner) exited() bool {
+	if r.command == nil {
+		return false
+	}
+	select {
+	case <-r.waited:
+		return r.command.ProcessState.Exited()
+	default:
+		return false
+	}
 }
 
 func (r *Runner) IsRunning() bool {
-	return r.command != nil && !r.Exited()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.command != nil && !r.exited()
 }
 
 func (r *Runner) runBin() error {
 	r.command = exec.Command(r.bin, r.args...)
+	r.waited = make(chan struct{})
 	r.command.Stdout = r.writer
-	r.command.Stderr = r.writer
+	r.command.Stderr = r.errWriter
+	env, err := r.environ()
+	if err != nil {
+		r.log.Err(err)
+	}
+	r.command.Env = env
 
-	err := r.command.Start()
+	err = r.command.Start()
 	if err != nil {
 		return err
 	}
 
 	r.starttime = time.Now()
+	r.events.Publish(EventRun, 0)
 
-	go r.command.Wait()
+	cmd, waited := r.command, r.waited
+	go func() {
+		cmd.Wait()
+		close(waited)
+	}()
 
 	return nil
 }
@@ -126,5 +181,7 @@ func (r *Runner) needsRefresh() bool {
 }
 
 func (r *Runner) Command() *exec.Cmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	return r.command
 }
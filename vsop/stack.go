@@ -0,0 +1,156 @@
+package vsop
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// panic: something, fatal error: something, goroutine 1 [running]:
+	reStackStart = regexp.MustCompile(`^(panic: |fatal error: |goroutine \d+ \[.*\]:$|SIG[A-Z]+: )`)
+	// 	/home/me/project/main.go:12 +0x1d
+	reStackFile = regexp.MustCompile(`^\t.+\.go:\d+( \+0x[0-9a-f]+)?$`)
+	// main.main(), github.com/x/y.(*T).Method(0xc000010000, ...), created by
+	// main.main in goroutine 1, panic({0x4a2c40, 0x525e88}), and main.run
+	// from github.com/pkg/errors traces. Only a frame when the next line is
+	// its file:line.
+	reStackFunc = regexp.MustCompile(`^(created by )?([\w\-.]+/)*[\w\-]+\.[\w\-.*()\[\]]+(\(.*\))?( in goroutine \d+)?$|^\w+\(.*\)$`)
+)
+
+// IsStackStart is true for the first line of a Go panic or goroutine dump
+func IsStackStart(line string) bool {
+	return reStackStart.MatchString(line)
+}
+
+// IsStackFrame is true for the file:line half of a stack frame
+func IsStackFrame(line string) bool {
+	return reStackFile.MatchString(line)
+}
+
+// StackFrameFile is the file path of a frame line, empty if it isn't one
+func StackFrameFile(line string) string {
+	if !IsStackFrame(line) {
+		return ""
+	}
+	line = strings.TrimSpace(line)
+	if i := strings.LastIndex(line, ".go:"); i >= 0 {
+		return line[:i+3]
+	}
+	return ""
+}
+
+// StackGrouper collects the lines of a panic, goroutine dump or stack trace
+// into one entry. Lines are held until the next line shows they aren't part
+// of a trace, or Quiet passes with nothing new.
+type StackGrouper struct {
+	Quiet time.Duration
+	// Emit is called with each plain line on its own, and with all the lines
+	// of a trace together
+	Emit func(lines []string, stack bool)
+
+	group []string
+	// stack is true once the group is known to be a trace
+	stack bool
+	// held is a line that looks like the function half of a frame, waiting
+	// to see if its file:line follows
+	held    string
+	holding bool
+}
+
+func NewStackGrouper(emit func(lines []string, stack bool)) *StackGrouper {
+	return &StackGrouper{Quiet: 50 * time.Millisecond, Emit: emit}
+}
+
+// Run groups lines until the channel is closed
+func (s *StackGrouper) Run(lines <-chan string) {
+	timer := time.NewTimer(s.Quiet)
+	timer.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				s.flush()
+				return
+			}
+			s.add(line)
+			timer.Reset(s.Quiet)
+		case <-timer.C:
+			s.flush()
+		}
+	}
+}
+
+func (s *StackGrouper) add(line string) {
+	if s.holding {
+		held := s.held
+		s.held, s.holding = "", false
+		if IsStackFrame(line) {
+			// A frame, it carries on the group, which may be the message a
+			// trace without a goroutine header belongs to
+			s.group = append(s.group, held, line)
+			s.stack = true
+			return
+		}
+		s.addLine(held)
+	}
+	if reStackFunc.MatchString(line) {
+		s.held, s.holding = line, true
+		return
+	}
+	s.addLine(line)
+}
+
+func (s *StackGrouper) addLine(line string) {
+	if len(s.group) > 0 && s.continues(line) {
+		s.group = append(s.group, line)
+		if IsStackFrame(line) || IsStackStart(line) {
+			s.stack = true
+		}
+		return
+	}
+
+	s.flush()
+	s.group = append(s.group, line)
+	s.stack = IsStackStart(line)
+}
+
+// continues is true when line could be the next line of the current group
+func (s *StackGrouper) continues(line string) bool {
+	if IsStackFrame(line) {
+		return true
+	}
+	if !s.stack {
+		return false
+	}
+	// Blank lines split goroutines, indented lines carry on a panic message
+	return line == "" || IsStackStart(line) || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "[signal ")
+}
+
+func (s *StackGrouper) flush() {
+	if s.holding {
+		// Nothing followed, it wasn't a frame
+		s.holding = false
+		s.addLine(s.held)
+		s.held = ""
+	}
+	if len(s.group) == 0 {
+		return
+	}
+
+	group := s.group
+	for len(group) > 1 && strings.TrimSpace(group[len(group)-1]) == "" {
+		group = group[:len(group)-1]
+	}
+
+	if s.stack && len(group) > 1 {
+		s.Emit(group, true)
+	} else {
+		// Turned out not to be a trace
+		for _, line := range s.group {
+			s.Emit([]string{line}, false)
+		}
+	}
+	s.group = nil
+	s.stack = false
+}
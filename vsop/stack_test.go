@@ -0,0 +1,145 @@
+package vsop
+
+import (
+	"reflect"
+	"testing"
+)
+
+// group runs lines through a StackGrouper and returns what it emits
+func group(lines []string) (groups [][]string, stacks []bool) {
+	s := NewStackGrouper(func(lines []string, stack bool) {
+		groups = append(groups, lines)
+		stacks = append(stacks, stack)
+	})
+	for _, line := range lines {
+		s.add(line)
+	}
+	s.flush()
+	return groups, stacks
+}
+
+func TestStackGrouper(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		groups [][]string
+		stacks []bool
+	}{
+		{
+			name:   "plain lines",
+			lines:  []string{"starting", "server.started", "listening on :3000"},
+			groups: [][]string{{"starting"}, {"server.started"}, {"listening on :3000"}},
+			stacks: []bool{false, false, false},
+		},
+		{
+			name:   "dotted line at the end",
+			lines:  []string{"ready", "config.loaded"},
+			groups: [][]string{{"ready"}, {"config.loaded"}},
+			stacks: []bool{false, false},
+		},
+		{
+			name: "panic with several goroutines",
+			lines: []string{
+				"panic: boom",
+				"",
+				"goroutine 1 [running]:",
+				"main.handler({0x6d2c40, 0xc0000a4000}, 0xc0000b2000)",
+				"\t/home/me/app/main.go:12 +0x1d",
+				"panic({0x4a2c40?, 0x525e88?})",
+				"\t/usr/local/go/src/runtime/panic.go:770 +0x132",
+				"",
+				"goroutine 7 [chan receive]:",
+				"main.worker()",
+				"\t/home/me/app/worker.go:8 +0x25",
+				"created by main.main in goroutine 1",
+				"\t/home/me/app/main.go:30 +0x65",
+				"exit status 2",
+			},
+			groups: [][]string{
+				{
+					"panic: boom",
+					"",
+					"goroutine 1 [running]:",
+					"main.handler({0x6d2c40, 0xc0000a4000}, 0xc0000b2000)",
+					"\t/home/me/app/main.go:12 +0x1d",
+					"panic({0x4a2c40?, 0x525e88?})",
+					"\t/usr/local/go/src/runtime/panic.go:770 +0x132",
+					"",
+					"goroutine 7 [chan receive]:",
+					"main.worker()",
+					"\t/home/me/app/worker.go:8 +0x25",
+					"created by main.main in goroutine 1",
+					"\t/home/me/app/main.go:30 +0x65",
+				},
+				{"exit status 2"},
+			},
+			stacks: []bool{true, false},
+		},
+		{
+			name: "pkg/errors trace",
+			lines: []string{
+				"open config: no such file",
+				"main.loadConfig",
+				"\t/home/me/app/config.go:21",
+				"github.com/me/app/server.(*Server).Start",
+				"\t/home/me/app/server/server.go:40",
+				"server.started",
+				"done",
+			},
+			groups: [][]string{
+				{
+					"open config: no such file",
+					"main.loadConfig",
+					"\t/home/me/app/config.go:21",
+					"github.com/me/app/server.(*Server).Start",
+					"\t/home/me/app/server/server.go:40",
+				},
+				{"server.started"},
+				{"done"},
+			},
+			stacks: []bool{true, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, stacks := group(tt.lines)
+			if !reflect.DeepEqual(groups, tt.groups) {
+				t.Errorf("groups = %q, want %q", groups, tt.groups)
+			}
+			if !reflect.DeepEqual(stacks, tt.stacks) {
+				t.Errorf("stacks = %v, want %v", stacks, tt.stacks)
+			}
+		})
+	}
+}
+
+func TestStackFrameFile(t *testing.T) {
+	tests := map[string]string{
+		"\t/home/me/app/main.go:12 +0x1d": "/home/me/app/main.go",
+		"\t/home/me/app/main.go:12":       "/home/me/app/main.go",
+		"main.main()":                     "",
+		"/home/me/app/main.go:12":         "",
+	}
+	for line, want := range tests {
+		if got := StackFrameFile(line); got != want {
+			t.Errorf("StackFrameFile(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestIsStackStart(t *testing.T) {
+	tests := map[string]bool{
+		"panic: boom": true,
+		"fatal error: all goroutines are asleep - deadlock!": true,
+		"goroutine 1 [running]:":                             true,
+		"SIGSEGV: segmentation violation":                    true,
+		"server panic: recovered":                            false,
+		"goroutine count is 12":                              false,
+	}
+	for line, want := range tests {
+		if got := IsStackStart(line); got != want {
+			t.Errorf("IsStackStart(%q) = %v, want %v", line, got, want)
+		}
+	}
+}
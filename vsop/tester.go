@@ -0,0 +1,335 @@
+package vsop
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Test results, as go test -json reports them
+const (
+	TestPass = "pass"
+	TestFail = "fail"
+	TestSkip = "skip"
+)
+
+// coverageLine is how go test reports a package's coverage
+var coverageLine = regexp.MustCompile(`coverage: (\d+(?:\.\d+)?)% of statements`)
+
+// testEvent is a line of go test -json output
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// TestResult of a single test, or of a whole package when Test is empty
+type TestResult struct {
+	Package string
+	Test    string
+	Action  string
+	Elapsed time.Duration
+	// Output the test printed, including the failure messages
+	Output string
+}
+
+// TestRun is the outcome of one go test run
+type TestRun struct {
+	// Packages that were asked for
+	Packages []string
+	// Results in the order they finished, tests before their package
+	Results []TestResult
+	Passed  int
+	Failed  int
+	Skipped int
+	// Output that doesn't belong to a test, like build errors
+	Output  string
+	Elapsed time.Duration
+	// ExitCode of go test, which isn't 0 when anything failed
+	ExitCode int
+	// Coverage of each package that reported it, in percent of statements
+	Coverage map[string]float64
+	// PrevCoverage of the packages in Coverage, from the last run that
+	// tested them
+	PrevCoverage map[string]float64
+}
+
+// Ok is true when nothing failed, including packages that didn't build
+func (t *TestRun) Ok() bool {
+	return t.ExitCode == 0
+}
+
+// FailedTests are the results of the tests that failed, packages that failed
+// without a failing test are included
+func (t *TestRun) FailedTests() []TestResult {
+	var failed []TestResult
+	hasTest := make(map[string]bool)
+	for _, r := range t.Results {
+		if r.Action == TestFail && r.Test != "" {
+			failed = append(failed, r)
+			hasTest[r.Package] = true
+		}
+	}
+	for _, r := range t.Results {
+		if r.Action == TestFail && r.Test == "" && !hasTest[r.Package] {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// PackageResults are the result of each package
+func (t *TestRun) PackageResults() []TestResult {
+	var pkgs []TestResult
+	for _, r := range t.Results {
+		if r.Test == "" {
+			pkgs = append(pkgs, r)
+		}
+	}
+	return pkgs
+}
+
+// Dropped are the packages whose coverage went down since the last run that
+// tested them, sorted
+func (t *TestRun) Dropped() []string {
+	var pkgs []string
+	for pkg, c := range t.Coverage {
+		if prev, ok := t.PrevCoverage[pkg]; ok && c < prev {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// Tester runs go test on the project
+type Tester struct {
+	dir    string
+	args   []string
+	run    string
+	events *EventHub
+
+	mu      sync.Mutex
+	last    *TestRun
+	running bool
+	// profile is the coverage profile go test writes, empty for no coverage
+	profile string
+	// coverage is the latest coverage of every package tested so far
+	coverage map[string]float64
+	// testMu lets one run happen at a time
+	testMu sync.Mutex
+}
+
+// NewTester for packages in dir, args are added to go test
+func NewTester(dir string, args []string) *Tester {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return &Tester{dir: dir, args: args, coverage: make(map[string]float64)}
+}
+
+// Dir is the absolute directory go test runs in
+func (t *Tester) Dir() string {
+	return t.dir
+}
+
+// SetRun limits runs to the tests matching the -run regex, empty for all
+func (t *Tester) SetRun(regex string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.run = regex
+}
+
+// SetCoverProfile is where go test writes the coverage profile of each run,
+// empty to not collect coverage
+func (t *Tester) SetCoverProfile(path string) error {
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		path = abs
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.profile = path
+	return nil
+}
+
+// SetEvents to publish test start and result on
+func (t *Tester) SetEvents(events *EventHub) {
+	t.events = events
+}
+
+// Last run, nil before the first
+func (t *Tester) Last() *TestRun {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// Running is true while go test runs
+func (t *Tester) Running() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.running
+}
+
+// Test runs go test -json on the packages. Failing tests aren't an error, the
+// error is for go test not running at all.
+func (t *Tester) Test(pkgs []string) (*TestRun, error) {
+	t.testMu.Lock()
+	defer t.testMu.Unlock()
+
+	t.mu.Lock()
+	t.running = true
+	args := append([]string{"test", "-json"}, t.args...)
+	if t.run != "" {
+		args = append(args, "-run", t.run)
+	}
+	if t.profile != "" {
+		args = append(args, "-coverprofile", t.profile)
+	}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.running = false
+		t.mu.Unlock()
+	}()
+
+	t.events.Publish(EventTestStart, 0)
+	start := time.Now()
+	cmd := exec.Command("go", append(args, pkgs...)...)
+	cmd.Dir = t.dir
+	output, err := cmd.CombinedOutput()
+	exitErr, ok := err.(*exec.ExitError)
+	if err != nil && !ok {
+		t.events.Publish(EventTestFail, 0)
+		return nil, err
+	}
+
+	run := parseTestOutput(output)
+	if exitErr != nil {
+		run.ExitCode = exitErr.ExitCode()
+	}
+	run.Packages = pkgs
+	run.Elapsed = time.Since(start)
+
+	t.mu.Lock()
+	run.PrevCoverage = make(map[string]float64)
+	for pkg, c := range run.Coverage {
+		if prev, ok := t.coverage[pkg]; ok {
+			run.PrevCoverage[pkg] = prev
+		}
+		t.coverage[pkg] = c
+	}
+	t.last = run
+	t.mu.Unlock()
+
+	if run.Ok() {
+		t.events.Publish(EventTestPass, 0)
+	} else {
+		t.events.Publish(EventTestFail, 0)
+	}
+	return run, nil
+}
+
+// CoverHTML writes an HTML coverage report of the last run to out
+func (t *Tester) CoverHTML(out string) error {
+	t.mu.Lock()
+	profile := t.profile
+	t.mu.Unlock()
+	if profile == "" {
+		return errors.New("coverage isn't being collected")
+	}
+	if _, err := os.Stat(profile); err != nil {
+		return errors.New("no coverage profile yet, run the tests first")
+	}
+
+	out, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("go", "tool", "cover", "-html="+profile, "-o", out)
+	cmd.Dir = t.dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrap(err, "go tool cover: "+strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// parseTestOutput turns go test -json output into results, lines that aren't
+// JSON are build errors or other noise from the go command
+func parseTestOutput(output []byte) *TestRun {
+	run := &TestRun{Coverage: make(map[string]float64)}
+	outputs := make(map[string]*strings.Builder)
+	var other strings.Builder
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var e testEvent
+		if !bytes.HasPrefix(line, []byte("{")) || json.Unmarshal(line, &e) != nil {
+			other.Write(line)
+			other.WriteByte('\n')
+			continue
+		}
+
+		key := e.Package + " " + e.Test
+		switch e.Action {
+		case "output", "build-output":
+			if e.Action == "build-output" {
+				other.WriteString(e.Output)
+				continue
+			}
+			if m := coverageLine.FindStringSubmatch(e.Output); m != nil && e.Test == "" {
+				if c, err := strconv.ParseFloat(m[1], 64); err == nil {
+					run.Coverage[e.Package] = c
+				}
+			}
+			if outputs[key] == nil {
+				outputs[key] = &strings.Builder{}
+			}
+			outputs[key].WriteString(e.Output)
+		case TestPass, TestFail, TestSkip:
+			r := TestResult{
+				Package: e.Package,
+				Test:    e.Test,
+				Action:  e.Action,
+				Elapsed: time.Duration(e.Elapsed * float64(time.Second)),
+			}
+			if out := outputs[key]; out != nil {
+				r.Output = out.String()
+			}
+			run.Results = append(run.Results, r)
+			if e.Test == "" {
+				continue
+			}
+			switch e.Action {
+			case TestPass:
+				run.Passed++
+			case TestFail:
+				run.Failed++
+			case TestSkip:
+				run.Skipped++
+			}
+		}
+	}
+
+	run.Output = strings.TrimSpace(other.String())
+	return run
+}
@@ -0,0 +1,119 @@
+package vsop
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testJSON joins go test -json lines into output
+func testJSON(lines ...string) []byte {
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func TestParseTestOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   []byte
+		results  []TestResult
+		passed   int
+		failed   int
+		skipped  int
+		coverage map[string]float64
+		other    string
+	}{
+		{
+			name: "pass",
+			output: testJSON(
+				`{"Action":"start","Package":"example.com/app/util"}`,
+				`{"Action":"run","Package":"example.com/app/util","Test":"TestAdd"}`,
+				`{"Action":"output","Package":"example.com/app/util","Test":"TestAdd","Output":"=== RUN   TestAdd\n"}`,
+				`{"Action":"output","Package":"example.com/app/util","Test":"TestAdd","Output":"--- PASS: TestAdd (0.00s)\n"}`,
+				`{"Action":"pass","Package":"example.com/app/util","Test":"TestAdd","Elapsed":0.25}`,
+				`{"Action":"output","Package":"example.com/app/util","Output":"coverage: 87.5% of statements\n"}`,
+				`{"Action":"pass","Package":"example.com/app/util","Elapsed":1.5}`,
+			),
+			results: []TestResult{
+				{Package: "example.com/app/util", Test: "TestAdd", Action: TestPass, Elapsed: 250 * time.Millisecond,
+					Output: "=== RUN   TestAdd\n--- PASS: TestAdd (0.00s)\n"},
+				{Package: "example.com/app/util", Action: TestPass, Elapsed: 1500 * time.Millisecond,
+					Output: "coverage: 87.5% of statements\n"},
+			},
+			passed:   1,
+			coverage: map[string]float64{"example.com/app/util": 87.5},
+		},
+		{
+			name: "fail and skip",
+			output: testJSON(
+				`{"Action":"run","Package":"example.com/app/api","Test":"TestGet"}`,
+				`{"Action":"output","Package":"example.com/app/api","Test":"TestGet","Output":"    api_test.go:12: got 1, want 2\n"}`,
+				`{"Action":"fail","Package":"example.com/app/api","Test":"TestGet","Elapsed":0}`,
+				`{"Action":"run","Package":"example.com/app/api","Test":"TestSlow"}`,
+				`{"Action":"output","Package":"example.com/app/api","Test":"TestSlow","Output":"    api_test.go:20: short mode\n"}`,
+				`{"Action":"skip","Package":"example.com/app/api","Test":"TestSlow","Elapsed":0}`,
+				`{"Action":"output","Package":"example.com/app/api","Output":"FAIL\n"}`,
+				`{"Action":"fail","Package":"example.com/app/api","Elapsed":0.5}`,
+			),
+			results: []TestResult{
+				{Package: "example.com/app/api", Test: "TestGet", Action: TestFail, Output: "    api_test.go:12: got 1, want 2\n"},
+				{Package: "example.com/app/api", Test: "TestSlow", Action: TestSkip, Output: "    api_test.go:20: short mode\n"},
+				{Package: "example.com/app/api", Action: TestFail, Elapsed: 500 * time.Millisecond, Output: "FAIL\n"},
+			},
+			failed:   1,
+			skipped:  1,
+			coverage: map[string]float64{},
+		},
+		{
+			name: "build failure",
+			output: testJSON(
+				`# example.com/app/api [example.com/app/api.test]`,
+				`api/api_test.go:9:2: undefined: Get`,
+				`{"ImportPath":"example.com/app/api [example.com/app/api.test]","Action":"build-output","Output":"vet: api/api_test.go:9:2: undefined: Get\n"}`,
+				`{"Action":"start","Package":"example.com/app/api"}`,
+				`{"Action":"output","Package":"example.com/app/api","Output":"FAIL\texample.com/app/api [build failed]\n"}`,
+				`{"Action":"fail","Package":"example.com/app/api","Elapsed":0}`,
+			),
+			results: []TestResult{
+				{Package: "example.com/app/api", Action: TestFail, Output: "FAIL\texample.com/app/api [build failed]\n"},
+			},
+			coverage: map[string]float64{},
+			other: "# example.com/app/api [example.com/app/api.test]\n" +
+				"api/api_test.go:9:2: undefined: Get\n" +
+				"vet: api/api_test.go:9:2: undefined: Get",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := parseTestOutput(tt.output)
+			if !reflect.DeepEqual(run.Results, tt.results) {
+				t.Errorf("results got %+v, want %+v", run.Results, tt.results)
+			}
+			if run.Passed != tt.passed || run.Failed != tt.failed || run.Skipped != tt.skipped {
+				t.Errorf("counts got %d/%d/%d, want %d/%d/%d", run.Passed, run.Failed, run.Skipped, tt.passed, tt.failed, tt.skipped)
+			}
+			if !reflect.DeepEqual(run.Coverage, tt.coverage) {
+				t.Errorf("coverage got %v, want %v", run.Coverage, tt.coverage)
+			}
+			if run.Output != tt.other {
+				t.Errorf("output got %q, want %q", run.Output, tt.other)
+			}
+		})
+	}
+}
+
+func TestFailedTests(t *testing.T) {
+	run := &TestRun{Results: []TestResult{
+		{Package: "a", Test: "TestOne", Action: TestFail},
+		{Package: "a", Action: TestFail},
+		{Package: "b", Action: TestFail},
+		{Package: "c", Test: "TestTwo", Action: TestPass},
+	}}
+	want := []TestResult{
+		{Package: "a", Test: "TestOne", Action: TestFail},
+		{Package: "b", Action: TestFail},
+	}
+	if got := run.FailedTests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}